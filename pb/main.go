@@ -5,16 +5,25 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 
 	"svpb-tmpl/pkg/config"
+	"svpb-tmpl/pkg/filter"
 	"svpb-tmpl/pkg/indexer"
+	"svpb-tmpl/pkg/ingest"
+	"svpb-tmpl/pkg/llm"
 	"svpb-tmpl/pkg/parser"
+	"svpb-tmpl/pkg/profiles"
 	"svpb-tmpl/pkg/rag"
+	"svpb-tmpl/pkg/sources"
+	"svpb-tmpl/pkg/vacancies"
+	"svpb-tmpl/pkg/watches"
 
 	"github.com/joho/godotenv"
 	"github.com/pocketbase/pocketbase"
+	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
 	"github.com/spf13/cobra"
@@ -102,6 +111,203 @@ func main() {
 		},
 	})
 
+	// Add tg-backfill command to index the existing history of whitelisted chats
+	var backfillOpts parser.BackfillOptions
+	backfillCmd := &cobra.Command{
+		Use:   "tg-backfill",
+		Short: "Index the message history of whitelisted chats",
+		Long:  "Walks the history of every enabled source from the oldest message and indexes it. Progress is saved per chat, so an interrupted run resumes where it stopped.",
+		Run: func(cmd *cobra.Command, args []string) {
+			logger, _ := zap.NewDevelopment()
+			defer logger.Sync()
+
+			if cfg.TgAPIID == 0 || cfg.TgAPIHash == "" {
+				logger.Fatal("TG_API_ID and TG_API_HASH must be set")
+			}
+
+			sourcesReg := sources.NewRegistry(app, logger)
+			if err := sourcesReg.Load(); err != nil {
+				logger.Fatal("Failed to load sources", zap.Error(err))
+			}
+			chatIDs := sourcesReg.ChatIDs()
+			if len(chatIDs) == 0 {
+				logger.Fatal("No enabled sources with a resolved peer ID")
+			}
+
+			indexerSvc, err := indexer.NewService(app, cfg, logger)
+			if err != nil {
+				logger.Fatal("Failed to initialize indexer", zap.Error(err))
+			}
+			setupFilter(app, cfg, indexerSvc, logger)
+
+			// LLM analysis of backfilled posts runs in the server's ingest workers
+			queue := ingest.NewQueue(app, cfg.IngestWorkers, cfg.IngestMaxAttempts, logger)
+			profilesSvc := profiles.NewService(app, llm.NewAnalyzer(cfg.OpenAIAPIKey, cfg.OpenAIBaseURL), logger)
+			if err := profilesSvc.Load(); err != nil {
+				logger.Fatal("Failed to load extraction profiles", zap.Error(err))
+			}
+			queueAnalysis(indexerSvc, sourcesReg, profilesSvc, queue, logger)
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			if err := indexerSvc.EnsureIndex(ctx); err != nil {
+				logger.Fatal("Failed to configure search index", zap.Error(err))
+			}
+
+			parserCfg := parser.Config{
+				APIID:       cfg.TgAPIID,
+				APIHash:     cfg.TgAPIHash,
+				Phone:       cfg.TgPhone,
+				SessionPath: cfg.TgSessionPath,
+			}
+
+			client := parser.NewClient(parserCfg, logger)
+			client.OnChannels(indexerSvc.RememberChannels)
+			docs := parser.NewDocumentReader(client.API(), cfg.TgMaxDocumentSize)
+			handler := parser.NewHandler(cfg, sourcesReg, indexerSvc, docs, nil, logger)
+			cursors := parser.NewCursorStore(app)
+
+			err = client.Run(ctx, func(ctx context.Context) error {
+				return client.Backfill(ctx, chatIDs, cursors, backfillOpts, handler.Index)
+			})
+			if err != nil && err != context.Canceled {
+				logger.Fatal("Backfill failed", zap.Error(err))
+			}
+		},
+	}
+	backfillCmd.Flags().IntVar(&backfillOpts.BatchSize, "batch", 100, "messages to request per getHistory call")
+	backfillCmd.Flags().IntVar(&backfillOpts.Limit, "limit", 0, "max messages to index per chat (0 = whole history)")
+	backfillCmd.Flags().IntVar(&backfillOpts.Workers, "workers", 8, "messages to index concurrently (batched by the indexer)")
+	app.RootCmd.AddCommand(backfillCmd)
+
+	// Add reindex command to rebuild the search index from the stored chunks
+	var reindexOpts indexer.ReindexOptions
+	reindexCmd := &cobra.Command{
+		Use:   "reindex",
+		Short: "Rebuild the chunks and vacancies search indexes from PocketBase",
+		Long:  "Streams the published chunks into a fresh search index and swaps it in place of the current one, then does the same for the vacancies index. Use --embed after changing the embedding model, dimensions or template (watch queries are re-embedded when the server starts).",
+		Run: func(cmd *cobra.Command, args []string) {
+			logger, _ := zap.NewDevelopment()
+			defer logger.Sync()
+
+			indexerSvc, err := indexer.NewService(app, cfg, logger)
+			if err != nil {
+				logger.Fatal("Failed to initialize indexer", zap.Error(err))
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			_, err = indexerSvc.Reindex(ctx, reindexOpts, func(p indexer.ReindexProgress) {
+				logger.Info("Reindex progress",
+					zap.Int("done", p.Done),
+					zap.Int("total", p.Total),
+					zap.Int("embedded", p.Embedded),
+				)
+			})
+			if err != nil {
+				logger.Fatal("Reindex failed", zap.Error(err))
+			}
+
+			vacanciesSvc := vacancies.NewService(app, cfg, nil, logger)
+			if _, err := vacanciesSvc.Reindex(ctx); err != nil {
+				logger.Fatal("Vacancies reindex failed", zap.Error(err))
+			}
+		},
+	}
+	reindexCmd.Flags().BoolVar(&reindexOpts.Embed, "embed", false, "re-embed every chunk with the configured model instead of copying the current vectors")
+	reindexCmd.Flags().IntVar(&reindexOpts.PageSize, "page", 500, "chunks to read from PocketBase at a time")
+	app.RootCmd.AddCommand(reindexCmd)
+
+	// Add reconcile command to repair differences between PocketBase and the search index
+	var reconcileDryRun bool
+	reconcileCmd := &cobra.Command{
+		Use:   "reconcile",
+		Short: "Repair differences between the chunks and vacancies collections and the search indexes",
+		Long:  "Indexes published chunks missing from the search index, rewrites documents older than their chunk and deletes documents of deleted or rejected chunks, then does the same for vacancies. Also runs on RECONCILE_SCHEDULE while the server is up.",
+		Run: func(cmd *cobra.Command, args []string) {
+			logger, _ := zap.NewDevelopment()
+			defer logger.Sync()
+
+			indexerSvc, err := indexer.NewService(app, cfg, logger)
+			if err != nil {
+				logger.Fatal("Failed to initialize indexer", zap.Error(err))
+			}
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			report, err := indexerSvc.Reconcile(ctx, reconcileDryRun)
+			if err != nil {
+				logger.Fatal("Reconcile failed", zap.Error(err))
+			}
+			logger.Info("Differences",
+				zap.Strings("missing", report.Missing),
+				zap.Strings("stale", report.Stale),
+				zap.Strings("orphaned", report.Orphaned),
+			)
+
+			vacanciesReport, err := vacancies.NewService(app, cfg, nil, logger).Reconcile(ctx, reconcileDryRun)
+			if err != nil {
+				logger.Fatal("Vacancies reconcile failed", zap.Error(err))
+			}
+			logger.Info("Vacancies differences",
+				zap.Strings("missing", vacanciesReport.Missing),
+				zap.Strings("stale", vacanciesReport.Stale),
+				zap.Strings("orphaned", vacanciesReport.Orphaned),
+			)
+		},
+	}
+	reconcileCmd.Flags().BoolVar(&reconcileDryRun, "dry-run", false, "only report the differences")
+	app.RootCmd.AddCommand(reconcileCmd)
+
+	// Add tg-relink command to rewrite chunk links of public channels
+	app.RootCmd.AddCommand(&cobra.Command{
+		Use:   "tg-relink",
+		Short: "Rewrite chunk links to public t.me/<username> links",
+		Long:  "Looks up the usernames of the account's channels and rewrites stored chunk links from the members-only t.me/c/ form to t.me/<username>/<id> where the channel is public.",
+		Run: func(cmd *cobra.Command, args []string) {
+			logger, _ := zap.NewDevelopment()
+			defer logger.Sync()
+
+			if cfg.TgAPIID == 0 || cfg.TgAPIHash == "" {
+				logger.Fatal("TG_API_ID and TG_API_HASH must be set")
+			}
+
+			indexerSvc, err := indexer.NewService(app, cfg, logger)
+			if err != nil {
+				logger.Fatal("Failed to initialize indexer", zap.Error(err))
+			}
+
+			parserCfg := parser.Config{
+				APIID:       cfg.TgAPIID,
+				APIHash:     cfg.TgAPIHash,
+				Phone:       cfg.TgPhone,
+				SessionPath: cfg.TgSessionPath,
+			}
+
+			client := parser.NewClient(parserCfg, logger)
+
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+
+			err = client.Run(ctx, func(ctx context.Context) error {
+				channels, err := client.Channels(ctx)
+				if err != nil {
+					return err
+				}
+				indexerSvc.RememberChannels(channels)
+
+				_, err = indexerSvc.Relink(ctx)
+				return err
+			})
+			if err != nil && err != context.Canceled {
+				logger.Fatal("Relink failed", zap.Error(err))
+			}
+		},
+	})
+
 	// Start services when server starts
 	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
 		logger, _ := zap.NewProduction()
@@ -113,10 +319,42 @@ func main() {
 			return se.Next()
 		}
 
-		// Ensure MeiliSearch index is configured
+		// Ensure the search index is configured
 		ctx := context.Background()
 		if err := indexerSvc.EnsureIndex(ctx); err != nil {
-			log.Printf("Failed to configure MeiliSearch index: %v", err)
+			log.Printf("Failed to configure search index: %v", err)
+		}
+
+		// Apply chunk writes to the search index, including the ones of other processes and admin edits
+		go indexerSvc.RunOutbox(ctx)
+
+		// Screen posts for spam; rules and verdict overrides take effect without a restart
+		filterRules := setupFilter(app, cfg, indexerSvc, logger)
+		filterRules.BindHooks()
+		indexerSvc.BindHooks()
+
+		// Load sources and keep them in sync with admin changes
+		sourcesReg := sources.NewRegistry(app, logger)
+		if err := sourcesReg.Load(); err != nil {
+			log.Printf("Failed to load sources: %v", err)
+		}
+		sourcesReg.BindHooks()
+
+		// Admin routes to rebuild the chunks index and follow its progress
+		se.Router.POST("/api/reindex", indexerSvc.HandleReindex).Bind(apis.RequireSuperuserAuth())
+		se.Router.GET("/api/reindex", indexerSvc.HandleReindexStatus).Bind(apis.RequireSuperuserAuth())
+
+		// Periodically repair documents missing from or left over in the search index
+		if cfg.ReconcileSchedule != "" {
+			if err := indexerSvc.ScheduleReconcile(cfg.ReconcileSchedule); err != nil {
+				log.Printf("Failed to schedule index reconciliation: %v", err)
+			}
+		}
+
+		// Evict stale cached embeddings and report cache hit rates to admins
+		if cache := indexerSvc.EmbeddingCache(); cache != nil {
+			cache.Schedule()
+			se.Router.GET("/api/embedding-cache/stats", cache.HandleStats).Bind(apis.RequireSuperuserAuth())
 		}
 
 		// Initialize RAG service
@@ -126,6 +364,61 @@ func main() {
 		se.Router.POST("/api/chat", ragSvc.HandleChat)
 		se.Router.GET("/api/chats/{chatId}/sse", ragSvc.HandleChatSSE)
 
+		// Durable ingestion queue, with an admin route to retry dead jobs
+		queue := ingest.NewQueue(app, cfg.IngestWorkers, cfg.IngestMaxAttempts, logger)
+		se.Router.POST("/api/ingest/requeue", queue.HandleRequeue).Bind(apis.RequireSuperuserAuth())
+
+		// Extract structured vacancies from job sources
+		analyzer := llm.NewAnalyzer(cfg.OpenAIAPIKey, cfg.OpenAIBaseURL)
+		vacanciesSvc := vacancies.NewService(app, cfg, analyzer, logger)
+		if err := vacanciesSvc.EnsureIndex(ctx); err != nil {
+			log.Printf("Failed to configure vacancies index: %v", err)
+		}
+		vacanciesSvc.BindHooks()
+		if vacanciesSvc.Searchable() {
+			se.Router.GET("/api/vacancies", vacanciesSvc.HandleSearch)
+
+			// Vacancy index writes are not retried: repair them with the chunks
+			if cfg.ReconcileSchedule != "" {
+				if err := vacanciesSvc.ScheduleReconcile(cfg.ReconcileSchedule); err != nil {
+					log.Printf("Failed to schedule vacancies index reconciliation: %v", err)
+				}
+			}
+		}
+
+		// Run user-defined extraction profiles on their sources
+		profilesSvc := profiles.NewService(app, analyzer, logger)
+		if err := profilesSvc.Load(); err != nil {
+			log.Printf("Failed to load extraction profiles: %v", err)
+		}
+		profilesSvc.BindHooks()
+		se.Router.GET("/api/extractions/{profile}", profilesSvc.HandleList)
+
+		queueAnalysis(indexerSvc, sourcesReg, profilesSvc, queue, logger)
+
+		// Run the analysis jobs right away, with or without Telegram; message jobs are
+		// handled once the Telegram client is connected (see startTelegramParser)
+		queue.Handle(ingest.KindAnalyze, func(ctx context.Context, job *ingest.Job) error {
+			return vacanciesSvc.Analyze(ctx, job.ChunkID)
+		})
+		queue.Handle(ingest.KindExtract, func(ctx context.Context, job *ingest.Job) error {
+			return profilesSvc.Extract(ctx, job.ProfileID, job.ChunkID)
+		})
+		go queue.Run(ctx)
+
+		// Alert users about new posts matching their watches (delivered once Telegram is running)
+		watchesSvc := watches.NewService(app, indexerSvc.GenerateEmbedding, indexerSvc.EmbeddingModel(), cfg.WatchRateLimit, logger)
+		if err := watchesSvc.Reembed(ctx); err != nil {
+			log.Printf("Failed to re-embed watches: %v", err)
+		}
+		if err := watchesSvc.Load(); err != nil {
+			log.Printf("Failed to load watches: %v", err)
+		}
+		watchesSvc.BindHooks()
+		se.Router.POST("/api/watches/link", watchesSvc.HandleLink).Bind(apis.RequireAuth("users"))
+		indexerSvc.OnIndexed(watchesSvc.HandleIndexed)
+		vacanciesSvc.OnVacancy(watchesSvc.HandleVacancy)
+
 		// Start Telegram parser if configured
 		if cfg.TgAPIID != 0 && cfg.TgAPIHash != "" {
 			// Check if session file exists
@@ -133,7 +426,7 @@ func main() {
 				log.Printf("Session file not found at %s - run 'tg-login' first", cfg.TgSessionPath)
 			} else {
 				// Start the parser in background
-				go startTelegramParser(cfg, indexerSvc, logger)
+				go startTelegramParser(app, cfg, sourcesReg, indexerSvc, queue, watchesSvc, logger)
 			}
 		} else {
 			log.Println("Telegram not configured (TG_API_ID/TG_API_HASH missing), skipping parser")
@@ -147,28 +440,128 @@ func main() {
 	}
 }
 
+// setupFilter loads the spam filter rules and installs the filter chain on the indexer:
+// PocketBase rules first, then the LLM classifier if enabled.
+func setupFilter(app core.App, cfg *config.Config, indexerSvc *indexer.Service, logger *zap.Logger) *filter.Rules {
+	rules := filter.NewRules(app, logger)
+	if err := rules.Load(); err != nil {
+		log.Printf("Failed to load filter rules: %v", err)
+	}
+
+	chain := filter.Chain{rules}
+	if cfg.SpamFilterLLM {
+		chain = append(chain, filter.NewClassifier(llm.NewAnalyzer(cfg.OpenAIAPIKey, cfg.OpenAIBaseURL)))
+	}
+	indexerSvc.SetFilter(chain)
+
+	return rules
+}
+
+// queueAnalysis enqueues LLM analysis for every indexed chunk: vacancy analysis for job
+// sources and the extraction profiles that apply to the chunk's channel.
+func queueAnalysis(indexerSvc *indexer.Service, sourcesReg *sources.Registry, profilesSvc *profiles.Service, queue *ingest.Queue, logger *zap.Logger) {
+	indexerSvc.OnIndexed(func(ctx context.Context, record *core.Record, _ []float32) {
+		if record.GetInt("position") > 0 {
+			// Long posts are analyzed once, as a whole, through their first chunk
+			return
+		}
+		channelID, _ := strconv.ParseInt(record.GetString("channelId"), 10, 64)
+
+		var jobs []*ingest.Job
+		if sourcesReg.IsJobSource(channelID) {
+			jobs = append(jobs, &ingest.Job{Kind: ingest.KindAnalyze, ChatID: channelID, ChunkID: record.Id})
+		}
+		for _, profileID := range profilesSvc.ProfilesFor(channelID) {
+			jobs = append(jobs, &ingest.Job{Kind: ingest.KindExtract, ChatID: channelID, ChunkID: record.Id, ProfileID: profileID})
+		}
+
+		for _, job := range jobs {
+			if err := queue.Enqueue(job); err != nil {
+				logger.Error("Failed to queue analysis", zap.String("kind", job.Kind), zap.String("chunk", record.Id), zap.Error(err))
+			}
+		}
+	})
+}
+
+// linkTelegramAccount links the sender of a "/start <code>" private message to the user the
+// code was issued to and replies with the outcome.
+func linkTelegramAccount(ctx context.Context, client *parser.Client, watchesSvc *watches.Service, msg parser.DirectMessage) error {
+	fields := strings.Fields(msg.Text)
+	if len(fields) != 2 || fields[0] != "/start" {
+		return nil
+	}
+
+	linked, err := watchesSvc.Link(strings.ToUpper(fields[1]), watches.Account{ID: msg.UserID, AccessHash: msg.AccessHash, Username: msg.Username})
+	if err != nil {
+		return err
+	}
+
+	reply := "This code is unknown or expired, request a new one."
+	if linked {
+		reply = "Telegram account linked: watch alerts will be sent here."
+	}
+	return client.SendMessageToUser(ctx, msg.UserID, msg.AccessHash, reply)
+}
+
 // startTelegramParser runs the Telegram message listener in the background.
-func startTelegramParser(cfg *config.Config, indexerSvc *indexer.Service, logger *zap.Logger) {
+func startTelegramParser(app core.App, cfg *config.Config, sourcesReg *sources.Registry, indexerSvc *indexer.Service, queue *ingest.Queue, watchesSvc *watches.Service, logger *zap.Logger) {
 	defer logger.Sync()
 
-	// Create handler
-	handler := parser.NewHandler(cfg, indexerSvc, logger)
-
 	// Create Telegram client
 	parserCfg := parser.Config{
 		APIID:       cfg.TgAPIID,
 		APIHash:     cfg.TgAPIHash,
 		Phone:       cfg.TgPhone,
 		SessionPath: cfg.TgSessionPath,
+
+		State:           parser.NewStateStorage(app),
+		TrackedChannels: sourcesReg.ChatIDs(),
 	}
 	tg := parser.NewClient(parserCfg, logger)
-	tg.OnNewMessage(handler.HandleMessage)
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	// Track the sources added at runtime too, not only the ones enabled at startup
+	sourcesReg.OnChange(func(chatIDs []int64) {
+		go func() {
+			if err := tg.TrackChannels(ctx, chatIDs); err != nil {
+				logger.Warn("Failed to track channels", zap.Error(err))
+			}
+		}()
+	})
+
+	// Create handler
+	docs := parser.NewDocumentReader(tg.API(), cfg.TgMaxDocumentSize)
+	handler := parser.NewHandler(cfg, sourcesReg, indexerSvc, docs, queue, logger)
+
+	tg.OnChannels(indexerSvc.RememberChannels)
+	tg.OnNewMessage(handler.HandleMessage)
+	tg.OnEditMessage(handler.HandleEdit)
+	tg.OnDeleteMessages(handler.HandleDelete)
+
+	// Resolve sources added by @username while the client is running
+	tg.OnStart(func(ctx context.Context) {
+		sourcesReg.Resolve(ctx, tg.ResolveSource)
+	})
+
+	// Deliver watch alerts through the Telegram account, to the accounts users linked with "/start <code>"
+	watchesSvc.SetSender(func(ctx context.Context, to watches.Account, text string) error {
+		return tg.SendMessageToUser(ctx, to.ID, to.AccessHash, text)
+	})
+	tg.OnDirectMessage(func(ctx context.Context, msg parser.DirectMessage) error {
+		return linkTelegramAccount(ctx, tg, watchesSvc, msg)
+	})
+
+	// Process message jobs once connected (documents are downloaded by the workers)
+	tg.OnStart(func(ctx context.Context) {
+		for _, kind := range []string{ingest.KindIndex, ingest.KindEdit, ingest.KindDelete} {
+			queue.Handle(kind, handler.Process)
+		}
+	})
+
 	logger.Info("Starting Telegram parser...",
-		zap.Int("targetChats", len(cfg.TargetChatIDs)),
+		zap.Int("sources", len(sourcesReg.ChatIDs())),
 	)
 
 	if err := tg.Start(ctx); err != nil {
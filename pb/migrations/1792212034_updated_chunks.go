@@ -0,0 +1,84 @@
+package migrations
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_4032739835")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE INDEX `+"`"+`idx_t6vz7jWiq7`+"`"+` ON `+"`"+`chunks`+"`"+` (\n  `+"`"+`channelId`+"`"+`,\n  `+"`"+`msgId`+"`"+`\n)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"hidden": false,
+			"id": "number4176922427",
+			"max": null,
+			"min": null,
+			"name": "msgId",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// backfill msgId of existing chunks from their https://t.me/c/<channelId>/<msgId> link
+		records, err := app.FindAllRecords("chunks")
+		if err != nil {
+			return err
+		}
+		for _, record := range records {
+			link := record.GetString("link")
+			msgID, err := strconv.Atoi(link[strings.LastIndex(link, "/")+1:])
+			if err != nil {
+				continue
+			}
+			record.Set("msgId", msgID)
+			if err := app.Save(record); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_4032739835")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": []
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("number4176922427")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+// Imports the chats whitelisted via the legacy TARGET_CHAT_IDS env var into the sources collection.
+func init() {
+	m.Register(func(app core.App) error {
+		col, err := app.FindCollectionByNameOrId("sources")
+		if err != nil {
+			return err
+		}
+
+		for _, id := range strings.Split(os.Getenv("TARGET_CHAT_IDS"), ",") {
+			id = strings.TrimSpace(id)
+			if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+				continue
+			}
+
+			rec := core.NewRecord(col)
+			rec.Set("peerId", id)
+			rec.Set("enabled", true)
+			if err := app.Save(rec); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		// add down queries...
+
+		return nil
+	})
+}
@@ -0,0 +1,88 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_4032739835")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE INDEX ` + "`" + `idx_t6vz7jWiq7` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (\n  ` + "`" + `channelId` + "`" + `,\n  ` + "`" + `msgId` + "`" + `\n)",
+				"CREATE INDEX ` + "`" + `idx_Qd5wHn2KcE` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (` + "`" + `contentHash` + "`" + `)",
+				"CREATE INDEX ` + "`" + `idx_Vr7jLs4XmB` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (` + "`" + `duplicateGroup` + "`" + `)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(13, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2299167369",
+			"max": 0,
+			"min": 0,
+			"name": "contentHash",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(14, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3824547738",
+			"max": 0,
+			"min": 0,
+			"name": "duplicateGroup",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_4032739835")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE INDEX ` + "`" + `idx_t6vz7jWiq7` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (\n  ` + "`" + `channelId` + "`" + `,\n  ` + "`" + `msgId` + "`" + `\n)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("text2299167369")
+
+		// remove field
+		collection.Fields.RemoveById("text3824547738")
+
+		return app.Save(collection)
+	})
+}
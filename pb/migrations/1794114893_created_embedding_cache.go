@@ -0,0 +1,151 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3518522040",
+					"max": 0,
+					"min": 0,
+					"name": "hash",
+					"pattern": "",
+					"presentable": true,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3616895705",
+					"max": 0,
+					"min": 0,
+					"name": "model",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "number3411638923",
+					"max": null,
+					"min": 1,
+					"name": "dims",
+					"onlyInt": true,
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "json460212315",
+					"maxSize": 0,
+					"name": "vector",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "json"
+				},
+				{
+					"hidden": false,
+					"id": "number445606955",
+					"max": null,
+					"min": 0,
+					"name": "hits",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "date3060926358",
+					"max": "",
+					"min": "",
+					"name": "lastUsed",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_2718093446",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_Ec7hQm2VtK` + "`" + ` ON ` + "`" + `embedding_cache` + "`" + ` (\n  ` + "`" + `hash` + "`" + `,\n  ` + "`" + `model` + "`" + `,\n  ` + "`" + `dims` + "`" + `\n)",
+				"CREATE INDEX ` + "`" + `idx_Ec4uLw9NsD` + "`" + ` ON ` + "`" + `embedding_cache` + "`" + ` (` + "`" + `lastUsed` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "embedding_cache",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_2718093446")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_4032739835")
+		if err != nil {
+			return err
+		}
+
+		// keep the first chunk saved for each message part, so the unique index can be created
+		// (the search index drops the documents of the others on its next reconciliation)
+		if _, err := app.DB().NewQuery("DELETE FROM `chunks` WHERE `msgId` > 0 AND `rowid` NOT IN (SELECT MIN(`rowid`) FROM `chunks` WHERE `msgId` > 0 GROUP BY `channelId`, `msgId`, `position`)").Execute(); err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_Mk8pRw3NvD` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (\n  ` + "`" + `channelId` + "`" + `,\n  ` + "`" + `msgId` + "`" + `,\n  ` + "`" + `position` + "`" + `\n) WHERE ` + "`" + `msgId` + "`" + ` > 0",
+				"CREATE INDEX ` + "`" + `idx_Qd5wHn2KcE` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (` + "`" + `contentHash` + "`" + `)",
+				"CREATE INDEX ` + "`" + `idx_Vr7jLs4XmB` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (` + "`" + `duplicateGroup` + "`" + `)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_4032739835")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE INDEX ` + "`" + `idx_t6vz7jWiq7` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (\n  ` + "`" + `channelId` + "`" + `,\n  ` + "`" + `msgId` + "`" + `\n)",
+				"CREATE INDEX ` + "`" + `idx_Qd5wHn2KcE` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (` + "`" + `contentHash` + "`" + `)",
+				"CREATE INDEX ` + "`" + `idx_Vr7jLs4XmB` + "`" + ` ON ` + "`" + `chunks` + "`" + ` (` + "`" + `duplicateGroup` + "`" + `)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	})
+}
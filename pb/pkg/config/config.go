@@ -3,7 +3,7 @@ package config
 import (
 	"os"
 	"strconv"
-	"strings"
+	"time"
 )
 
 // Config holds all application configuration.
@@ -13,17 +13,48 @@ type Config struct {
 	TgAPIHash     string
 	TgPhone       string
 	TgSessionPath string
-	TargetChatIDs []int64 // Whitelisted channel/chat IDs
+	TgMaxDocumentSize int64 // Max size in bytes of document attachments to download and index
 
 	// MeiliSearch
 	MeiliHost   string
 	MeiliMasterKey string
+	Retriever      string // Chunks search backend: "meili" or "sqlite" (embedded, no MeiliSearch needed; vacancy search is then disabled)
 
 	// OpenAI
 	OpenAIAPIKey  string
 	OpenAIBaseURL string
+
+	// Embeddings
+	Embedder       string // "openai" (any OpenAI-compatible API), "ollama" or "hash" (offline)
+	EmbedderURL    string // Embeddings API base URL; defaults to OPENAI_BASE_URL for openai and localhost for ollama
+	EmbeddingModel string
+	EmbeddingDims  int // Changing it requires rebuilding the index
+	EmbeddingCacheSize int           // Max cached embeddings, least recently used evicted first (0 disables the cache)
+	EmbeddingCacheTTL  time.Duration // Cached embeddings unused for longer are evicted (0 = no expiry)
+
+	// Indexing
+	ChunkDeleteMode string // "delete" removes chunks of deleted messages, "tombstone" keeps them flagged as deleted
+	IngestWorkers     int // Number of workers draining the ingest_jobs queue
+	IngestMaxAttempts int // Attempts before an ingest job is marked dead
+	SpamFilterLLM     bool // Classify posts with the LLM in addition to the filter_rules collection
+	DuplicateSimilarity float64 // Min cosine similarity of the content embeddings (without the embedding template) for a chunk to join an existing duplicate group (0 disables)
+	ChunkMaxTokens      int     // Posts longer than this (estimated) are split into several chunks
+	ChunkOverlapTokens  int     // Tokens each chunk repeats from the end of the previous one
+	EmbeddingTemplate   string  // Go template of the text embedded for a chunk (see indexer.EmbeddingContext), empty for the default
+	IndexBatchSize      int           // Max chunks embedded and indexed together (at least 1)
+	IndexBatchWindow    time.Duration // Max wait for more messages to fill a batch
+	ReconcileSchedule   string        // Cron schedule of the PocketBase/MeiliSearch reconciler (empty disables it)
+
+	// Watches
+	WatchRateLimit int // Max Telegram alerts per user per hour (0 = unlimited)
 }
 
+// Chunk delete modes.
+const (
+	ChunkDeleteModeDelete    = "delete"
+	ChunkDeleteModeTombstone = "tombstone"
+)
+
 // Load reads configuration from environment variables.
 func Load() *Config {
 	apiID, _ := strconv.Atoi(os.Getenv("TG_API_ID"))
@@ -34,15 +65,40 @@ func Load() *Config {
 		TgAPIHash:     os.Getenv("TG_API_HASH"),
 		TgPhone:       os.Getenv("TG_PHONE"),
 		TgSessionPath: getEnvOrDefault("TG_SESSION_PATH", "session.json"),
-		TargetChatIDs: parseIntList(os.Getenv("TARGET_CHAT_IDS")),
+		TgMaxDocumentSize: getEnvInt64OrDefault("TG_MAX_DOCUMENT_SIZE", 10<<20),
 
 		// MeiliSearch
 		MeiliHost:   getEnvOrDefault("MEILI_HOST", "http://meilisearch:7700"),
 		MeiliMasterKey: os.Getenv("MEILI_MASTER_KEY"),
+		Retriever:      getEnvOrDefault("RETRIEVER", "meili"),
 
 		// OpenAI
 		OpenAIAPIKey:  os.Getenv("OPENAI_API_KEY"),
 		OpenAIBaseURL: os.Getenv("OPENAI_BASE_URL"),
+
+		// Embeddings
+		Embedder:       getEnvOrDefault("EMBEDDER", "openai"),
+		EmbedderURL:    os.Getenv("EMBEDDER_URL"),
+		EmbeddingModel: getEnvOrDefault("EMBEDDING_MODEL", "voyage/voyage-3.5-lite"),
+		EmbeddingDims:  getEnvIntOrDefault("EMBEDDING_DIMS", 1024),
+		EmbeddingCacheSize: getEnvIntOrDefault("EMBEDDING_CACHE_SIZE", 50000),
+		EmbeddingCacheTTL:  time.Duration(getEnvIntOrDefault("EMBEDDING_CACHE_TTL_DAYS", 90)) * 24 * time.Hour,
+
+		// Indexing
+		ChunkDeleteMode: getEnvOrDefault("CHUNK_DELETE_MODE", ChunkDeleteModeDelete),
+		IngestWorkers:     getEnvIntOrDefault("INGEST_WORKERS", 2),
+		IngestMaxAttempts: getEnvIntOrDefault("INGEST_MAX_ATTEMPTS", 8),
+		SpamFilterLLM:     os.Getenv("SPAM_FILTER_LLM") == "true",
+		DuplicateSimilarity: getEnvFloatOrDefault("DUPLICATE_SIMILARITY", 0.95),
+		ChunkMaxTokens:      getEnvIntOrDefault("CHUNK_MAX_TOKENS", 512),
+		ChunkOverlapTokens:  getEnvIntOrDefault("CHUNK_OVERLAP_TOKENS", 64),
+		EmbeddingTemplate:   os.Getenv("EMBEDDING_TEMPLATE"),
+		IndexBatchSize:      max(getEnvIntOrDefault("INDEX_BATCH_SIZE", 64), 1),
+		IndexBatchWindow:    time.Duration(getEnvIntOrDefault("INDEX_BATCH_WINDOW_MS", 200)) * time.Millisecond,
+		ReconcileSchedule:   getEnvOrDefault("RECONCILE_SCHEDULE", "*/30 * * * *"),
+
+		// Watches
+		WatchRateLimit: getEnvIntOrDefault("WATCH_RATE_LIMIT", 20),
 	}
 }
 
@@ -53,35 +109,23 @@ func getEnvOrDefault(key, defaultVal string) string {
 	return defaultVal
 }
 
-// parseIntList parses a comma-separated list of int64 values.
-func parseIntList(s string) []int64 {
-	if s == "" {
-		return nil
-	}
-	parts := strings.Split(s, ",")
-	result := make([]int64, 0, len(parts))
-	for _, p := range parts {
-		p = strings.TrimSpace(p)
-		if p == "" {
-			continue
-		}
-		if v, err := strconv.ParseInt(p, 10, 64); err == nil {
-			result = append(result, v)
-		}
+func getEnvIntOrDefault(key string, defaultVal int) int {
+	if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return v
 	}
-	return result
+	return defaultVal
 }
 
-// IsChatAllowed checks if the given chat ID is in the whitelist.
-// If no whitelist is configured, all chats are allowed.
-func (c *Config) IsChatAllowed(chatID int64) bool {
-	if len(c.TargetChatIDs) == 0 {
-		return true
+func getEnvInt64OrDefault(key string, defaultVal int64) int64 {
+	if v, err := strconv.ParseInt(os.Getenv(key), 10, 64); err == nil {
+		return v
 	}
-	for _, id := range c.TargetChatIDs {
-		if id == chatID {
-			return true
-		}
+	return defaultVal
+}
+
+func getEnvFloatOrDefault(key string, defaultVal float64) float64 {
+	if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+		return v
 	}
-	return false
+	return defaultVal
 }
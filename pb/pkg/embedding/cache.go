@@ -0,0 +1,244 @@
+package embedding
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"go.uber.org/zap"
+)
+
+const cacheCollection = "embedding_cache"
+
+// Cache is an Embedder that remembers the vectors of another one in PocketBase, keyed by
+// the hash of the text, the model and the dimensions. Reposts, unchanged edits, reindexing
+// and repeated questions are embedded once.
+type Cache struct {
+	app     core.App
+	next    Embedder
+	maxSize int           // max entries, least recently used evicted first
+	ttl     time.Duration // entries unused for longer are evicted (0 = no expiry)
+	logger  *zap.Logger
+
+	hits    atomic.Int64
+	misses  atomic.Int64
+	evicted atomic.Int64
+}
+
+// CacheStats are the cache statistics since startup.
+type CacheStats struct {
+	Model   string  `json:"model"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	HitRate float64 `json:"hitRate"`
+	Evicted int64   `json:"evicted"`
+	Entries int64   `json:"entries"` // currently stored, all models included
+}
+
+// NewCache wraps next with a cache of at most maxSize entries.
+func NewCache(app core.App, next Embedder, maxSize int, ttl time.Duration, logger *zap.Logger) *Cache {
+	return &Cache{
+		app:     app,
+		next:    next,
+		maxSize: maxSize,
+		ttl:     ttl,
+		logger:  logger,
+	}
+}
+
+// Embed returns the cached vectors and embeds the other texts in one call to the wrapped embedder.
+// Cache failures are logged and fall back to embedding.
+func (c *Cache) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	hashes := make([]string, len(texts))
+	for i, text := range texts {
+		hashes[i] = textHash(text)
+	}
+
+	cached, err := c.lookup(hashes)
+	if err != nil {
+		c.logger.Warn("Failed to read embedding cache", zap.Error(err))
+	}
+
+	// Embed each missing text once, even if it repeats
+	vectors := make([][]float32, len(texts))
+	var missing []string
+	positions := make(map[string][]int)
+	for i, hash := range hashes {
+		if vector, ok := cached[hash]; ok {
+			vectors[i] = vector
+			continue
+		}
+		if _, ok := positions[hash]; !ok {
+			missing = append(missing, texts[i])
+		}
+		positions[hash] = append(positions[hash], i)
+	}
+
+	c.hits.Add(int64(len(texts) - len(missing)))
+	c.misses.Add(int64(len(missing)))
+
+	if len(missing) == 0 {
+		return vectors, nil
+	}
+
+	embedded, err := c.next.Embed(ctx, missing)
+	if err != nil {
+		return nil, err
+	}
+	for k, text := range missing {
+		hash := textHash(text)
+		for _, i := range positions[hash] {
+			vectors[i] = embedded[k]
+		}
+		c.store(hash, embedded[k])
+	}
+
+	return vectors, nil
+}
+
+func (c *Cache) Model() string   { return c.next.Model() }
+func (c *Cache) Dimensions() int { return c.next.Dimensions() }
+
+// lookup finds the cached vectors of the given hashes and marks them as used.
+func (c *Cache) lookup(hashes []string) (map[string][]float32, error) {
+	values := make([]interface{}, len(hashes))
+	for i, hash := range hashes {
+		values[i] = hash
+	}
+
+	records, err := c.app.FindAllRecords(cacheCollection,
+		dbx.In("hash", values...),
+		dbx.HashExp{"model": c.Model(), "dims": c.Dimensions()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make(map[string][]float32, len(records))
+	ids := make([]interface{}, 0, len(records))
+	for _, record := range records {
+		var vector []float32
+		if err := record.UnmarshalJSONField("vector", &vector); err != nil || len(vector) != c.Dimensions() {
+			continue
+		}
+		vectors[record.GetString("hash")] = vector
+		ids = append(ids, record.Id)
+	}
+
+	if len(ids) > 0 {
+		_, err = c.app.DB().Update(cacheCollection, dbx.Params{
+			"hits":     dbx.NewExp("[[hits]] + 1"),
+			"lastUsed": types.NowDateTime().String(),
+		}, dbx.In("id", ids...)).Execute()
+		if err != nil {
+			c.logger.Warn("Failed to update embedding cache usage", zap.Error(err))
+		}
+	}
+
+	return vectors, nil
+}
+
+// store caches a vector. A concurrent call may have stored it already, which the unique
+// index rejects.
+func (c *Cache) store(hash string, vector []float32) {
+	collection, err := c.app.FindCollectionByNameOrId(cacheCollection)
+	if err != nil {
+		c.logger.Warn("Failed to find embedding cache collection", zap.Error(err))
+		return
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("hash", hash)
+	record.Set("model", c.Model())
+	record.Set("dims", c.Dimensions())
+	record.Set("vector", vector)
+	record.Set("lastUsed", types.NowDateTime())
+	if err := c.app.Save(record); err != nil {
+		c.logger.Debug("Failed to cache embedding", zap.String("hash", hash), zap.Error(err))
+	}
+}
+
+// Evict removes the entries unused for longer than the TTL, then the least recently used
+// ones above the max size, and returns how many were removed.
+func (c *Cache) Evict() (int64, error) {
+	var removed int64
+
+	if c.ttl > 0 {
+		cutoff := types.NowDateTime().Add(-c.ttl).String()
+		result, err := c.app.DB().Delete(cacheCollection, dbx.NewExp("[[lastUsed]] < {:cutoff}", dbx.Params{"cutoff": cutoff})).Execute()
+		if err != nil {
+			return removed, fmt.Errorf("failed to evict expired embeddings: %w", err)
+		}
+		n, _ := result.RowsAffected()
+		removed += n
+	}
+
+	result, err := c.app.DB().NewQuery(
+		"DELETE FROM {{" + cacheCollection + "}} WHERE [[id]] IN (" +
+			"SELECT [[id]] FROM {{" + cacheCollection + "}} ORDER BY [[lastUsed]] DESC, [[created]] DESC LIMIT -1 OFFSET {:max})",
+	).Bind(dbx.Params{"max": c.maxSize}).Execute()
+	if err != nil {
+		return removed, fmt.Errorf("failed to evict least recently used embeddings: %w", err)
+	}
+	n, _ := result.RowsAffected()
+	removed += n
+
+	c.evicted.Add(removed)
+	return removed, nil
+}
+
+// Schedule runs the eviction hourly with the app cron.
+func (c *Cache) Schedule() {
+	c.app.Cron().MustAdd("embeddingCacheEvict", "0 * * * *", func() {
+		removed, err := c.Evict()
+		if err != nil {
+			c.logger.Error("Embedding cache eviction failed", zap.Error(err))
+			return
+		}
+		if removed > 0 {
+			c.logger.Info("Embedding cache evicted", zap.Int64("entries", removed))
+		}
+	})
+}
+
+// Stats returns the cache statistics since startup.
+func (c *Cache) Stats() (CacheStats, error) {
+	stats := CacheStats{
+		Model:   c.Model(),
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Evicted: c.evicted.Load(),
+	}
+	if total := stats.Hits + stats.Misses; total > 0 {
+		stats.HitRate = float64(stats.Hits) / float64(total)
+	}
+
+	entries, err := c.app.CountRecords(cacheCollection)
+	if err != nil {
+		return stats, fmt.Errorf("failed to count cached embeddings: %w", err)
+	}
+	stats.Entries = entries
+
+	return stats, nil
+}
+
+// HandleStats is an admin route returning the cache statistics.
+func (c *Cache) HandleStats(e *core.RequestEvent) error {
+	stats, err := c.Stats()
+	if err != nil {
+		return e.InternalServerError("Failed to read embedding cache stats", err)
+	}
+	return e.JSON(200, stats)
+}
+
+// textHash is the cache key of a text: its exact content, since any change may change the vector.
+func textHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
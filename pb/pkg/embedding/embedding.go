@@ -0,0 +1,78 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"svpb-tmpl/pkg/config"
+)
+
+// Embedder backends.
+const (
+	BackendOpenAI = "openai" // OpenAI-compatible /embeddings API (OpenAI, OpenRouter, llama.cpp server, ...)
+	BackendOllama = "ollama" // Ollama /api/embed
+	BackendHash   = "hash"   // Deterministic feature hashing, for offline runs
+)
+
+// Embedder turns texts into vectors of a fixed size.
+type Embedder interface {
+	// Embed returns one vector per text, in order.
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// Model names the model the vectors come from.
+	Model() string
+	// Dimensions is the size of the vectors.
+	Dimensions() int
+}
+
+// New creates the embedder selected in the config.
+func New(cfg *config.Config) (Embedder, error) {
+	if cfg.EmbeddingDims <= 0 {
+		return nil, fmt.Errorf("invalid embedding dimensions: %d", cfg.EmbeddingDims)
+	}
+
+	switch cfg.Embedder {
+	case BackendOpenAI, "":
+		baseURL := cfg.EmbedderURL
+		if baseURL == "" {
+			baseURL = cfg.OpenAIBaseURL
+		}
+		return NewOpenAI(cfg.OpenAIAPIKey, baseURL, cfg.EmbeddingModel, cfg.EmbeddingDims), nil
+	case BackendOllama:
+		return NewOllama(cfg.EmbedderURL, cfg.EmbeddingModel, cfg.EmbeddingDims), nil
+	case BackendHash:
+		return NewHash(cfg.EmbeddingDims), nil
+	default:
+		return nil, fmt.Errorf("unknown embedder: %s", cfg.Embedder)
+	}
+}
+
+// checkVectors verifies a backend returned one vector of the expected size per text.
+func checkVectors(vectors [][]float32, texts, dims int) error {
+	if len(vectors) != texts {
+		return fmt.Errorf("got %d embeddings for %d texts", len(vectors), texts)
+	}
+	for _, v := range vectors {
+		if len(v) != dims {
+			return fmt.Errorf("got embedding of %d dimensions, expected %d", len(v), dims)
+		}
+	}
+	return nil
+}
+
+// Cosine is the cosine similarity of two vectors, 0 when their sizes differ or either is zero.
+func Cosine(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
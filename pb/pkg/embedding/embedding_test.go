@@ -0,0 +1,56 @@
+package embedding
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckVectors(t *testing.T) {
+	tests := []struct {
+		name    string
+		vectors [][]float32
+		texts   int
+		dims    int
+		wantErr bool
+	}{
+		{name: "ok", vectors: [][]float32{{1, 2, 3}, {4, 5, 6}}, texts: 2, dims: 3},
+		{name: "none", vectors: nil, texts: 0, dims: 3},
+		{name: "missing vector", vectors: [][]float32{{1, 2, 3}}, texts: 2, dims: 3, wantErr: true},
+		{name: "extra vector", vectors: [][]float32{{1, 2, 3}, {4, 5, 6}}, texts: 1, dims: 3, wantErr: true},
+		{name: "wrong size", vectors: [][]float32{{1, 2, 3}, {4, 5}}, texts: 2, dims: 3, wantErr: true},
+		{name: "empty vector", vectors: [][]float32{{}}, texts: 1, dims: 3, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVectors(tt.vectors, tt.texts, tt.dims)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkVectors() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCosine(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{name: "same", a: []float32{1, 2, 3}, b: []float32{1, 2, 3}, want: 1},
+		{name: "scaled", a: []float32{1, 2, 3}, b: []float32{2, 4, 6}, want: 1},
+		{name: "orthogonal", a: []float32{1, 0}, b: []float32{0, 1}, want: 0},
+		{name: "opposite", a: []float32{1, 0}, b: []float32{-1, 0}, want: -1},
+		{name: "different sizes", a: []float32{1, 0}, b: []float32{1, 0, 0}, want: 0},
+		{name: "zero vector", a: []float32{0, 0}, b: []float32{1, 0}, want: 0},
+		{name: "empty", a: nil, b: nil, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Cosine(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 {
+				t.Fatalf("Cosine() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package embedding
+
+import (
+	"context"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// Hash is a deterministic embedder for offline development and tests: words and word
+// pairs are hashed into signed buckets (the "hashing trick"), so texts sharing words
+// get similar vectors. It needs no network, but captures no meaning beyond word overlap.
+type Hash struct {
+	dims int
+}
+
+// NewHash creates a hashing embedder producing vectors of the given size.
+func NewHash(dims int) *Hash {
+	return &Hash{dims: dims}
+}
+
+func (e *Hash) Embed(_ context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vectors[i] = e.embed(text)
+	}
+	return vectors, nil
+}
+
+func (e *Hash) embed(text string) []float32 {
+	vector := make([]float32, e.dims)
+
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		e.add(vector, word, 1)
+		if i > 0 {
+			e.add(vector, words[i-1]+" "+word, 0.5)
+		}
+	}
+
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm > 0 {
+		scale := float32(1 / math.Sqrt(norm))
+		for i := range vector {
+			vector[i] *= scale
+		}
+	}
+
+	return vector
+}
+
+func (e *Hash) add(vector []float32, feature string, weight float32) {
+	h := fnv.New64a()
+	h.Write([]byte(feature))
+	sum := h.Sum64()
+
+	if sum>>63 == 1 {
+		weight = -weight
+	}
+	vector[sum%uint64(e.dims)] += weight
+}
+
+func (e *Hash) Model() string   { return "hash" }
+func (e *Hash) Dimensions() int { return e.dims }
@@ -0,0 +1,66 @@
+package embedding
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		zero bool
+	}{
+		{name: "words", text: "Senior Go developer, remote"},
+		{name: "cyrillic", text: "Ищем Go разработчика в команду"},
+		{name: "repeated words", text: "go go go go"},
+		{name: "empty", text: "", zero: true},
+		{name: "punctuation only", text: "?! ... --", zero: true},
+	}
+
+	e := NewHash(64)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			first, err := e.Embed(context.Background(), []string{tt.text})
+			if err != nil {
+				t.Fatal(err)
+			}
+			second, _ := e.Embed(context.Background(), []string{tt.text})
+
+			if err := checkVectors(first, 1, 64); err != nil {
+				t.Fatal(err)
+			}
+			for i := range first[0] {
+				if first[0][i] != second[0][i] {
+					t.Fatalf("not deterministic at %d: %v != %v", i, first[0][i], second[0][i])
+				}
+			}
+
+			var norm float64
+			for _, v := range first[0] {
+				norm += float64(v) * float64(v)
+			}
+			want := 1.0
+			if tt.zero {
+				want = 0
+			}
+			if math.Abs(math.Sqrt(norm)-want) > 1e-6 {
+				t.Fatalf("norm = %v, want %v", math.Sqrt(norm), want)
+			}
+		})
+	}
+}
+
+func TestHashSimilarity(t *testing.T) {
+	vectors, _ := NewHash(256).Embed(context.Background(), []string{
+		"Senior Go developer wanted, remote",
+		"Wanted: senior Go developer (remote)",
+		"Selling a used bike in Berlin",
+	})
+
+	similar, unrelated := Cosine(vectors[0], vectors[1]), Cosine(vectors[0], vectors[2])
+	if similar <= unrelated {
+		t.Fatalf("texts sharing words are not closer: %v <= %v", similar, unrelated)
+	}
+}
@@ -0,0 +1,79 @@
+package embedding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DefaultOllamaURL is where a local Ollama server listens by default.
+const DefaultOllamaURL = "http://localhost:11434"
+
+// Ollama embeds texts with a local Ollama server.
+type Ollama struct {
+	url    string
+	model  string
+	dims   int
+	client *http.Client
+}
+
+// NewOllama creates an embedder for the Ollama server at url (DefaultOllamaURL if empty).
+func NewOllama(url, model string, dims int) *Ollama {
+	if url == "" {
+		url = DefaultOllamaURL
+	}
+
+	return &Ollama{
+		url:    strings.TrimSuffix(url, "/"),
+		model:  model,
+		dims:   dims,
+		client: &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+func (e *Ollama) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"model": e.model,
+		"input": texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url+"/api/embed", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call ollama: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("ollama returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var result struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode ollama response: %w", err)
+	}
+
+	if err := checkVectors(result.Embeddings, len(texts), e.dims); err != nil {
+		return nil, err
+	}
+	return result.Embeddings, nil
+}
+
+func (e *Ollama) Model() string   { return e.model }
+func (e *Ollama) Dimensions() int { return e.dims }
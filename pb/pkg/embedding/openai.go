@@ -0,0 +1,55 @@
+package embedding
+
+import (
+	"context"
+	"fmt"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// OpenAI embeds texts through an OpenAI-compatible embeddings API.
+type OpenAI struct {
+	client *openai.Client
+	model  string
+	dims   int
+}
+
+// NewOpenAI creates an embedder for an OpenAI-compatible API; baseURL may be empty for OpenAI itself.
+func NewOpenAI(apiKey, baseURL, model string, dims int) *OpenAI {
+	openaiConfig := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		openaiConfig.BaseURL = baseURL
+	}
+
+	return &OpenAI{
+		client: openai.NewClientWithConfig(openaiConfig),
+		model:  model,
+		dims:   dims,
+	}
+}
+
+func (e *OpenAI) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Model: openai.EmbeddingModel(e.model),
+		Input: texts,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, d := range resp.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding index %d out of range", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+
+	if err := checkVectors(vectors, len(texts), e.dims); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+func (e *OpenAI) Model() string   { return e.model }
+func (e *OpenAI) Dimensions() int { return e.dims }
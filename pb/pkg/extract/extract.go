@@ -0,0 +1,178 @@
+package extract
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ErrUnsupported is returned for file types text cannot be extracted from.
+var ErrUnsupported = errors.New("unsupported document type")
+
+// maxDocxBody caps the decompressed size of a DOCX body, against zip bombs.
+const maxDocxBody = 50 << 20
+
+const (
+	mimePDF      = "application/pdf"
+	mimeDOCX     = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	mimeText     = "text/plain"
+	mimeMarkdown = "text/markdown"
+)
+
+// Supported reports whether text can be extracted from a file with the given MIME type and name.
+func Supported(mimeType, fileName string) bool {
+	return kind(mimeType, fileName) != ""
+}
+
+// Text extracts plain text from a PDF, DOCX, plain text or markdown file.
+func Text(data []byte, mimeType, fileName string) (string, error) {
+	var (
+		text string
+		err  error
+	)
+
+	switch kind(mimeType, fileName) {
+	case mimePDF:
+		text, err = pdfText(data)
+	case mimeDOCX:
+		text, err = docxText(data)
+	case mimeText, mimeMarkdown:
+		if !utf8.Valid(data) {
+			return "", fmt.Errorf("%s is not valid UTF-8", fileName)
+		}
+		text = string(data)
+	default:
+		return "", ErrUnsupported
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(text), nil
+}
+
+// kind normalizes a document type using its MIME type, falling back to the file extension
+// (Telegram clients often send text files as application/octet-stream).
+func kind(mimeType, fileName string) string {
+	switch mimeType {
+	case mimePDF, mimeDOCX, mimeText, mimeMarkdown:
+		return mimeType
+	case "text/x-markdown":
+		return mimeMarkdown
+	}
+
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".pdf":
+		return mimePDF
+	case ".docx":
+		return mimeDOCX
+	case ".txt":
+		return mimeText
+	case ".md", ".markdown":
+		return mimeMarkdown
+	}
+	return ""
+}
+
+// pdfText reads the text of every page. The PDF library panics on some malformed files,
+// which are reported as errors instead.
+func pdfText(data []byte) (text string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("failed to read malformed PDF: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open PDF: %w", err)
+	}
+
+	var sb strings.Builder
+	for i := 1; i <= r.NumPage(); i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		pageText, err := page.GetPlainText(nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to read PDF page %d: %w", i, err)
+		}
+		sb.WriteString(pageText)
+		sb.WriteString("\n\n")
+	}
+
+	return sb.String(), nil
+}
+
+// docxText reads the paragraphs of word/document.xml from a DOCX archive, up to maxDocxBody
+// bytes of XML.
+func docxText(data []byte) (string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("failed to open DOCX: %w", err)
+	}
+
+	for _, f := range zr.File {
+		if f.Name != "word/document.xml" {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", fmt.Errorf("failed to open DOCX body: %w", err)
+		}
+		defer rc.Close()
+		return docxBody(io.LimitReader(rc, maxDocxBody))
+	}
+
+	return "", errors.New("DOCX has no word/document.xml")
+}
+
+func docxBody(r io.Reader) (string, error) {
+	var sb strings.Builder
+	dec := xml.NewDecoder(r)
+	inText := false
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to parse DOCX body: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteByte('\t')
+			case "br":
+				sb.WriteByte('\n')
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteByte('\n')
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+
+	return sb.String(), nil
+}
@@ -0,0 +1,58 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+
+	"svpb-tmpl/pkg/llm"
+)
+
+// Verdict is the outcome of screening a post.
+type Verdict struct {
+	Rejected bool
+	Reason   string
+}
+
+// Filter screens post text before indexing.
+type Filter interface {
+	Check(ctx context.Context, text string) (Verdict, error)
+}
+
+// Chain runs filters in order; the first rejection wins.
+type Chain []Filter
+
+// Check implements Filter.
+func (c Chain) Check(ctx context.Context, text string) (Verdict, error) {
+	for _, f := range c {
+		verdict, err := f.Check(ctx, text)
+		if err != nil {
+			return Verdict{}, err
+		}
+		if verdict.Rejected {
+			return verdict, nil
+		}
+	}
+	return Verdict{}, nil
+}
+
+// Classifier rejects spam and advertisements using an LLM.
+type Classifier struct {
+	analyzer *llm.Analyzer
+}
+
+// NewClassifier creates an LLM-backed filter.
+func NewClassifier(analyzer *llm.Analyzer) *Classifier {
+	return &Classifier{analyzer: analyzer}
+}
+
+// Check implements Filter.
+func (c *Classifier) Check(ctx context.Context, text string) (Verdict, error) {
+	res, err := c.analyzer.ClassifySpam(ctx, text)
+	if err != nil {
+		return Verdict{}, fmt.Errorf("failed to classify post: %w", err)
+	}
+	if !res.IsSpam {
+		return Verdict{}, nil
+	}
+	return Verdict{Rejected: true, Reason: "llm: " + res.Reason}, nil
+}
@@ -0,0 +1,101 @@
+package filter
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+const RulesCollectionName = "filter_rules"
+
+type rule struct {
+	keyword string         // lowercased, for keyword rules
+	re      *regexp.Regexp // for regex rules
+	reason  string
+}
+
+// Rules rejects posts matching the keyword and regex rules of the filter_rules collection.
+// Keywords match case-insensitively anywhere in the text.
+type Rules struct {
+	app    core.App
+	logger *zap.Logger
+
+	mu    sync.RWMutex
+	rules []rule
+}
+
+// NewRules creates a new rule-based filter.
+func NewRules(app core.App, logger *zap.Logger) *Rules {
+	return &Rules{
+		app:    app,
+		logger: logger,
+	}
+}
+
+// Load reads the enabled rules from PocketBase. Invalid regexes are logged and skipped.
+func (r *Rules) Load() error {
+	records, err := r.app.FindAllRecords(RulesCollectionName, dbx.HashExp{"enabled": true})
+	if err != nil {
+		return fmt.Errorf("failed to load filter rules: %w", err)
+	}
+
+	rules := make([]rule, 0, len(records))
+	for _, record := range records {
+		pattern := record.GetString("pattern")
+		ru := rule{reason: record.GetString("reason")}
+		if ru.reason == "" {
+			ru.reason = fmt.Sprintf("matches %q", pattern)
+		}
+
+		if record.GetString("type") == "regex" {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				r.logger.Warn("Invalid filter rule, skipping", zap.String("id", record.Id), zap.Error(err))
+				continue
+			}
+			ru.re = re
+		} else {
+			ru.keyword = strings.ToLower(pattern)
+		}
+		rules = append(rules, ru)
+	}
+
+	r.mu.Lock()
+	r.rules = rules
+	r.mu.Unlock()
+
+	return nil
+}
+
+// BindHooks reloads the rules whenever one is created, updated or deleted.
+func (r *Rules) BindHooks() {
+	onChange := func(e *core.RecordEvent) error {
+		if err := r.Load(); err != nil {
+			r.logger.Error("Failed to reload filter rules", zap.Error(err))
+		}
+		return e.Next()
+	}
+	r.app.OnRecordAfterCreateSuccess(RulesCollectionName).BindFunc(onChange)
+	r.app.OnRecordAfterUpdateSuccess(RulesCollectionName).BindFunc(onChange)
+	r.app.OnRecordAfterDeleteSuccess(RulesCollectionName).BindFunc(onChange)
+}
+
+// Check implements Filter.
+func (r *Rules) Check(_ context.Context, text string) (Verdict, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	lower := strings.ToLower(text)
+	for _, ru := range r.rules {
+		if ru.re != nil && ru.re.MatchString(text) || ru.re == nil && strings.Contains(lower, ru.keyword) {
+			return Verdict{Rejected: true, Reason: ru.reason}, nil
+		}
+	}
+	return Verdict{}, nil
+}
@@ -0,0 +1,148 @@
+package indexer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// Album is the chunks.meta entry of a post sent as an album: several messages
+// sharing a grouped ID, merged into a single chunk.
+type Album struct {
+	GroupedID int64       `json:"groupedId,string"`
+	Parts     []AlbumPart `json:"parts"`
+}
+
+// AlbumPart is the indexed text of one message of an album.
+type AlbumPart struct {
+	MsgID int                    `json:"msgId"`
+	Text  string                 `json:"text"`
+	Meta  map[string]interface{} `json:"meta,omitempty"`
+}
+
+// text joins the non-empty texts of the album parts in message order.
+func (a *Album) text() string {
+	texts := make([]string, 0, len(a.Parts))
+	for _, part := range a.Parts {
+		if part.Text != "" {
+			texts = append(texts, part.Text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
+
+// merge adds the posts to the album, replacing the parts of messages already in it.
+func (a *Album) merge(posts []Post) {
+	for _, post := range posts {
+		part := AlbumPart{MsgID: post.Message.ID, Text: post.Text, Meta: post.Meta}
+
+		replaced := false
+		for i := range a.Parts {
+			if a.Parts[i].MsgID == part.MsgID {
+				a.Parts[i] = part
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			a.Parts = append(a.Parts, part)
+		}
+	}
+
+	sort.Slice(a.Parts, func(i, j int) bool { return a.Parts[i].MsgID < a.Parts[j].MsgID })
+}
+
+// IndexAlbum indexes the messages of an album as a single post linked to its first message.
+// Messages of an album that is already indexed (late arrivals, backfilled parts, edits)
+// are merged into the existing chunks, which are then re-embedded.
+func (s *Service) IndexAlbum(ctx context.Context, channelID, groupedID int64, posts []Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	record, err := s.findAlbumChunk(channelID, groupedID)
+	if err != nil {
+		return fmt.Errorf("failed to look up album chunk: %w", err)
+	}
+
+	if record == nil {
+		album := &Album{GroupedID: groupedID}
+		album.merge(posts)
+
+		// The first message of the album is the canonical one
+		first := posts[0]
+		for _, post := range posts {
+			if post.Message.ID < first.Message.ID {
+				first = post
+			}
+		}
+
+		return s.IndexMessage(ctx, Post{
+			ChannelID: channelID,
+			Message:   first.Message,
+			Text:      album.text(),
+			Meta:      map[string]interface{}{"album": album},
+		})
+	}
+
+	var meta struct {
+		Album *Album `json:"album"`
+	}
+	if err := record.UnmarshalJSONField("meta", &meta); err != nil {
+		return fmt.Errorf("failed to read album of chunk %s: %w", record.Id, err)
+	}
+	album := meta.Album
+	if album == nil {
+		album = &Album{GroupedID: groupedID}
+	}
+	album.merge(posts)
+
+	records, err := s.findParts(record)
+	if err != nil {
+		return err
+	}
+
+	text := album.text()
+	parts := s.split(text)
+	if text == "" || sameParts(records, parts) {
+		return nil
+	}
+
+	// Screen, save and replace the indexed documents (same primary keys)
+	record.Set("meta", map[string]interface{}{"album": album})
+	verdict, err := s.replaceParts(ctx, text, records, parts)
+	if err != nil {
+		return err
+	}
+	if verdict.Rejected {
+		return nil
+	}
+
+	s.logger.Info("Album re-indexed",
+		zap.String("id", record.Id),
+		zap.Int64("channelId", channelID),
+		zap.Int64("groupedId", groupedID),
+		zap.Int("parts", len(album.Parts)),
+	)
+
+	return nil
+}
+
+// findAlbumChunk returns the chunk indexed for the given album, or nil if there is none.
+func (s *Service) findAlbumChunk(channelID, groupedID int64) (*core.Record, error) {
+	record, err := s.app.FindFirstRecordByFilter("chunks", "channelId = {:channelId} && meta.album.groupedId = {:groupedId}", dbx.Params{
+		"channelId": fmt.Sprintf("%d", channelID),
+		"groupedId": fmt.Sprintf("%d", groupedID),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
@@ -0,0 +1,234 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// batchItem is an accepted message whose chunks wait to be embedded, saved and indexed.
+type batchItem struct {
+	records    []*core.Record
+	data       EmbeddingContext
+	embeddings [][]float32 // one per record, set by the batch
+	contents   [][]float32 // content-only embeddings, one per record
+	done       chan error
+}
+
+// submit queues the chunks of an accepted message for the next batch and waits for its result.
+// Messages submitted concurrently (ingest workers, parallel backfill) within the batch window
+// share one embeddings request, one PocketBase transaction and one outbox dispatch.
+func (s *Service) submit(ctx context.Context, records []*core.Record, data EmbeddingContext) ([][]float32, error) {
+	s.batchOnce.Do(func() { go s.runBatches() })
+
+	item := &batchItem{records: records, data: data, done: make(chan error, 1)}
+	select {
+	case s.batch <- item:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	select {
+	case err := <-item.done:
+		return item.embeddings, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// runBatches collects submitted messages until the batch holds batchSize chunks or the
+// window since its first message elapses, then indexes them. New messages queue up
+// while a batch is being indexed.
+func (s *Service) runBatches() {
+	for first := range s.batch {
+		items := []*batchItem{first}
+		size := len(first.records)
+
+		timer := time.NewTimer(s.batchWindow)
+	collect:
+		for size < s.batchSize {
+			select {
+			case item := <-s.batch:
+				items = append(items, item)
+				size += len(item.records)
+			case <-timer.C:
+				break collect
+			}
+		}
+		timer.Stop()
+
+		s.indexBatch(context.Background(), items)
+	}
+}
+
+// indexBatch embeds and saves a batch, then applies the outbox to the index. Each step is
+// done for the whole batch at once and, if that fails, retried message by message, so a
+// failure is reported only to the messages it concerns. Once saved, a message is indexed
+// even if the index is unavailable: the outbox dispatcher retries.
+func (s *Service) indexBatch(ctx context.Context, items []*batchItem) {
+	start := time.Now()
+	errs := make([]error, len(items))
+
+	s.embedBatch(ctx, items, errs)
+
+	hashes := make(batchHashes)
+	for i, item := range items {
+		if errs[i] != nil {
+			continue
+		}
+		for j, record := range item.records {
+			// Link reposts of already indexed posts, or of posts of the batch, into a duplicate group
+			s.assignDuplicateGroup(ctx, record, item.contents[j], hashes)
+
+			record.Set("verdict", VerdictAccepted)
+			if !record.GetBool("override") {
+				record.Set("verdictReason", "")
+			}
+		}
+	}
+
+	s.saveBatch(items, errs)
+	s.flushOutbox(ctx)
+
+	chunks, failed := 0, 0
+	for i, item := range items {
+		chunks += len(item.records)
+		if errs[i] != nil {
+			failed++
+		}
+		item.done <- errs[i]
+	}
+
+	s.logger.Debug("Batch indexed",
+		zap.Int("messages", len(items)),
+		zap.Int("chunks", chunks),
+		zap.Int("failed", failed),
+		zap.Duration("took", time.Since(start)),
+	)
+}
+
+// embedBatch embeds the chunks of the batch items without an error in one request: each
+// chunk rendered with the embedding template and its content alone.
+func (s *Service) embedBatch(ctx context.Context, items []*batchItem, errs []error) {
+	embed := func(items []*batchItem) error {
+		var inputs []string
+		for _, item := range items {
+			for _, record := range item.records {
+				content := record.GetString("content")
+				inputs = append(inputs, s.embeddingInput(item.data, content), content)
+			}
+		}
+
+		vectors, err := s.embedDistinct(ctx, inputs)
+		if err != nil {
+			return fmt.Errorf("failed to generate embedding: %w", err)
+		}
+		for _, item := range items {
+			item.embeddings = make([][]float32, len(item.records))
+			item.contents = make([][]float32, len(item.records))
+			for j := range item.records {
+				item.embeddings[j], item.contents[j], vectors = vectors[0], vectors[1], vectors[2:]
+			}
+		}
+		return nil
+	}
+
+	s.eachOrAll(items, errs, embed)
+}
+
+// embedDistinct embeds texts in one request, sending each distinct text once: with the
+// template rendering the content alone, both vectors of a chunk are the same.
+func (s *Service) embedDistinct(ctx context.Context, texts []string) ([][]float32, error) {
+	var distinct []string
+	positions := make([]int, len(texts))
+	seen := make(map[string]int, len(texts))
+	for i, text := range texts {
+		p, ok := seen[text]
+		if !ok {
+			p = len(distinct)
+			seen[text] = p
+			distinct = append(distinct, text)
+		}
+		positions[i] = p
+	}
+
+	vectors, err := s.embedder.Embed(ctx, distinct)
+	if err != nil {
+		return nil, err
+	}
+
+	embeddings := make([][]float32, len(texts))
+	for i, p := range positions {
+		embeddings[i] = vectors[p]
+	}
+	return embeddings, nil
+}
+
+// saveBatch saves the chunks of the batch items without an error in one transaction,
+// with their outbox entries.
+func (s *Service) saveBatch(items []*batchItem, errs []error) {
+	save := func(items []*batchItem) error {
+		var created []*core.Record
+		err := s.app.RunInTransaction(func(txApp core.App) error {
+			for _, item := range items {
+				for j, record := range item.records {
+					if record.IsNew() {
+						created = append(created, record)
+					}
+
+					// The outbox hook passes the embeddings to the dispatcher
+					s.vectors.Store(record, chunkVectors{search: item.embeddings[j], content: item.contents[j]})
+					err := txApp.Save(record)
+					s.vectors.Delete(record)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			// The records were rolled back: save them as new again on retry
+			for _, record := range created {
+				record.MarkAsNew()
+			}
+			return fmt.Errorf("failed to save to PocketBase: %w", err)
+		}
+		return nil
+	}
+
+	s.eachOrAll(items, errs, save)
+}
+
+// eachOrAll runs f on all the items without an error at once and, if it fails, on each
+// of them alone, recording the errors.
+func (s *Service) eachOrAll(items []*batchItem, errs []error, f func([]*batchItem) error) {
+	var pending []*batchItem
+	var indexes []int
+	for i, item := range items {
+		if errs[i] == nil {
+			pending = append(pending, item)
+			indexes = append(indexes, i)
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	err := f(pending)
+	if err == nil {
+		return
+	}
+	if len(pending) == 1 {
+		errs[indexes[0]] = err
+		return
+	}
+
+	s.logger.Warn("Batch step failed, retrying message by message", zap.Int("messages", len(pending)), zap.Error(err))
+	for k, item := range pending {
+		errs[indexes[k]] = f([]*batchItem{item})
+	}
+}
@@ -0,0 +1,165 @@
+package indexer
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"svpb-tmpl/pkg/embedding"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// duplicateCandidates is the number of nearest indexed chunks compared with a new chunk.
+const duplicateCandidates = 5
+
+// contentHash hashes a text with case and whitespace normalized, so trivially
+// reformatted reposts hash the same.
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(strings.ToLower(text)), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// batchHashes maps the content hashes of the chunks of a batch to the first chunk with
+// each hash. The chunks are saved together, so they cannot find each other in PocketBase.
+type batchHashes map[string]*core.Record
+
+// assignDuplicateGroup sets the content hash of a chunk and links it to the duplicate group
+// of an earlier chunk of the batch or an indexed chunk with the same hash or, failing that,
+// a close enough content vector (the content embedded alone, without the template). A group
+// is identified by its canonical chunk (the first one indexed), whose own duplicateGroup
+// stays empty.
+func (s *Service) assignDuplicateGroup(ctx context.Context, record *core.Record, contentVector []float32, batch batchHashes) {
+	hash := contentHash(record.GetString("content"))
+	record.Set("contentHash", hash)
+
+	var group string
+	if first := batch[hash]; first != nil && !sameMessage(first, record) {
+		group = groupOf(first.Id, first.GetString("duplicateGroup"))
+	} else {
+		var err error
+		group, err = s.findDuplicateGroup(ctx, record, hash, contentVector)
+		if err != nil {
+			// A duplicate in the results beats a lost post
+			s.logger.Warn("Duplicate detection failed, indexing as unique",
+				zap.String("channelId", record.GetString("channelId")),
+				zap.Int("msgId", record.GetInt("msgId")),
+				zap.Error(err),
+			)
+		}
+		if first == nil {
+			// Later chunks of the batch link to it before it is saved
+			if record.Id == "" {
+				record.Id = core.GenerateDefaultRandomId()
+			}
+			batch[hash] = record
+		}
+	}
+	record.Set("duplicateGroup", group)
+
+	if group != "" {
+		s.logger.Info("Post is a duplicate",
+			zap.String("group", group),
+			zap.String("channelId", record.GetString("channelId")),
+			zap.Int("msgId", record.GetInt("msgId")),
+		)
+	}
+}
+
+// findDuplicateGroup returns the canonical chunk ID of the group the chunk duplicates,
+// or "" if it is unique. An edited canonical chunk never joins a group of its own duplicates.
+func (s *Service) findDuplicateGroup(ctx context.Context, record *core.Record, hash string, contentVector []float32) (string, error) {
+	filter := "contentHash = {:hash} && deleted = false && (verdict != 'rejected' || override = true)"
+	if !record.IsNew() {
+		filter += " && id != {:id} && duplicateGroup != {:id}"
+	}
+	match, err := s.app.FindFirstRecordByFilter("chunks", filter, dbx.Params{"hash": hash, "id": record.Id})
+	if err == nil {
+		return groupOf(match.Id, match.GetString("duplicateGroup")), nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("failed to look up content hash: %w", err)
+	}
+
+	if s.duplicateSimilarity <= 0 {
+		return "", nil
+	}
+
+	// Only accepted chunks are in the index, so no need to filter here. The search vectors
+	// embed the post context (channel, date, ...), which differs between a post and its
+	// reposts, so the stored content vectors are compared instead.
+	nearest, err := s.retriever.Nearest(ctx, contentVector, duplicateCandidates)
+	if err != nil {
+		return "", err
+	}
+
+	best, bestScore := "", s.duplicateSimilarity
+	for _, c := range nearest {
+		group := groupOf(c.ID, c.GroupID)
+		if !record.IsNew() && (c.ID == record.Id || group == record.Id) {
+			continue
+		}
+		if score := embedding.Cosine(contentVector, c.vectors().content); score >= bestScore {
+			best, bestScore = group, score
+		}
+	}
+
+	return best, nil
+}
+
+// attachGroupLinks sets the links of all the chunks in the duplicate group of each
+// document, canonical chunk first.
+func (s *Service) attachGroupLinks(docs []ChunkDocument) {
+	groups := make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		if doc.GroupID != "" {
+			groups = append(groups, doc.GroupID)
+		}
+	}
+	if len(groups) == 0 {
+		return
+	}
+
+	records, err := s.app.FindAllRecords("chunks",
+		dbx.Or(dbx.In("id", groups...), dbx.In("duplicateGroup", groups...)),
+		dbx.HashExp{"deleted": false},
+		dbx.Or(dbx.Not(dbx.HashExp{"verdict": VerdictRejected}), dbx.HashExp{"override": true}),
+	)
+	if err != nil {
+		s.logger.Warn("Failed to load duplicate groups", zap.Error(err))
+		return
+	}
+
+	links := make(map[string][]string, len(groups))
+	for _, record := range records {
+		group := groupOf(record.Id, record.GetString("duplicateGroup"))
+		if record.Id == group {
+			links[group] = append([]string{record.GetString("link")}, links[group]...)
+		} else {
+			links[group] = append(links[group], record.GetString("link"))
+		}
+	}
+
+	for i := range docs {
+		docs[i].Links = links[docs[i].GroupID]
+	}
+}
+
+// sameMessage reports whether two chunks are parts of the same message.
+func sameMessage(a, b *core.Record) bool {
+	return a.GetInt("msgId") != 0 && a.GetInt("msgId") == b.GetInt("msgId") && a.GetString("channelId") == b.GetString("channelId")
+}
+
+// groupOf returns the duplicate group of a chunk: its canonical chunk, or itself.
+func groupOf(id, duplicateGroup string) string {
+	if duplicateGroup != "" {
+		return duplicateGroup
+	}
+	return id
+}
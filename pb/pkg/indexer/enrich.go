@@ -0,0 +1,169 @@
+package indexer
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"text/template"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// DefaultEmbeddingTemplate prefixes the chunk content with the context of its post,
+// so short posts ("Yes, it's released now") still embed close to their topic.
+const DefaultEmbeddingTemplate = `{{with .Channel}}Channel: {{.}}
+{{end}}{{if not .Date.IsZero}}Date: {{.Date.Format "2006-01-02"}}
+{{end}}{{with .ForwardedFrom}}Forwarded from: {{.}}
+{{end}}{{with .ReplyTo}}In reply to: {{.}}
+{{end}}
+{{.Content}}`
+
+// replySnippetLength caps the parent text included for replies.
+const replySnippetLength = 300
+
+// EmbeddingContext is the data of the embedding template. Everything is derived from
+// stored records (the head chunk's raw message, sources, indexed parent posts), so
+// reindexing re-applies a changed template.
+type EmbeddingContext struct {
+	Channel       string    // Channel title, or @username
+	Date          time.Time // Post date
+	ReplyTo       string    // Text of the post this one replies to, truncated
+	ForwardedFrom string    // Name of the original author of a forwarded post
+	Content       string    // The chunk content
+}
+
+// rawMessage is the part of a stored tg.Message (chunks.raw) used for enrichment.
+type rawMessage struct {
+	Date    int64 `json:"Date"`
+	ReplyTo *struct {
+		ReplyToMsgID  int      `json:"ReplyToMsgID"`
+		ReplyToPeerID *rawPeer `json:"ReplyToPeerID"`
+	} `json:"ReplyTo"`
+	FwdFrom *struct {
+		FromID     *rawPeer `json:"FromID"`
+		FromName   string   `json:"FromName"`
+		PostAuthor string   `json:"PostAuthor"`
+	} `json:"FwdFrom"`
+}
+
+type rawPeer struct {
+	ChannelID int64 `json:"ChannelID"`
+}
+
+// PostDate returns when the post of a head chunk was published in Telegram, falling back to
+// the chunk's creation for chunks stored without their raw message. Backfilled and requeued
+// posts are indexed long after they were published.
+func PostDate(head *core.Record) time.Time {
+	var raw rawMessage
+	if err := head.UnmarshalJSONField("raw", &raw); err == nil && raw.Date > 0 {
+		return time.Unix(raw.Date, 0).UTC()
+	}
+	return head.GetDateTime("created").Time()
+}
+
+// parseEmbeddingTemplate parses the configured template, falling back to the default.
+func parseEmbeddingTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		text = DefaultEmbeddingTemplate
+	}
+	tmpl, err := template.New("embedding").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid embedding template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// embeddingContext gathers the context of the post a head chunk belongs to.
+func (s *Service) embeddingContext(head *core.Record) EmbeddingContext {
+	channelID, _ := strconv.ParseInt(head.GetString("channelId"), 10, 64)
+	data := EmbeddingContext{Channel: s.channelTitle(channelID)}
+
+	var raw rawMessage
+	if err := head.UnmarshalJSONField("raw", &raw); err != nil {
+		return data
+	}
+	if raw.Date > 0 {
+		data.Date = time.Unix(raw.Date, 0).UTC()
+	}
+
+	if raw.ReplyTo != nil && raw.ReplyTo.ReplyToMsgID != 0 && raw.ReplyTo.ReplyToPeerID == nil {
+		// Only replies within the same chat, whose parent may be indexed
+		parent, err := s.FindChunk(channelID, raw.ReplyTo.ReplyToMsgID)
+		if err != nil {
+			s.logger.Warn("Failed to look up reply parent", zap.String("id", head.Id), zap.Error(err))
+		} else if parent != nil {
+			text, err := MessageText(s.app, parent)
+			if err == nil {
+				data.ReplyTo = truncate(text, replySnippetLength)
+			}
+		}
+	}
+
+	if fwd := raw.FwdFrom; fwd != nil {
+		switch {
+		case fwd.FromName != "":
+			data.ForwardedFrom = fwd.FromName
+		case fwd.FromID != nil && fwd.FromID.ChannelID != 0:
+			data.ForwardedFrom = s.channelTitle(fwd.FromID.ChannelID)
+		}
+		if fwd.PostAuthor != "" {
+			if data.ForwardedFrom != "" {
+				data.ForwardedFrom += " (" + fwd.PostAuthor + ")"
+			} else {
+				data.ForwardedFrom = fwd.PostAuthor
+			}
+		}
+	}
+
+	return data
+}
+
+// embeddingInput renders the text embedded for a chunk.
+func (s *Service) embeddingInput(data EmbeddingContext, content string) string {
+	data.Content = content
+
+	var b bytes.Buffer
+	if err := s.template.Execute(&b, data); err != nil {
+		s.logger.Warn("Failed to render embedding template, embedding content only", zap.Error(err))
+		return content
+	}
+	return b.String()
+}
+
+// channelTitle returns the title of a channel from the Telegram updates seen so far or
+// the sources collection, falling back to its public @username.
+func (s *Service) channelTitle(channelID int64) string {
+	if channelID == 0 {
+		return ""
+	}
+	if title, ok := s.titles.Load(channelID); ok {
+		return title.(string)
+	}
+
+	source, err := s.app.FindFirstRecordByFilter("sources", "peerId = {:peerId}", dbx.Params{"peerId": fmt.Sprintf("%d", channelID)})
+	if err == nil && source.GetString("title") != "" {
+		s.titles.Store(channelID, source.GetString("title"))
+		return source.GetString("title")
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		s.logger.Warn("Failed to look up source title", zap.Int64("channelId", channelID), zap.Error(err))
+	}
+
+	if username, ok := s.usernames.Load(channelID); ok {
+		return "@" + username.(string)
+	}
+	return ""
+}
+
+func truncate(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
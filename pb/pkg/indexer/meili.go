@@ -0,0 +1,316 @@
+package indexer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/meilisearch/meilisearch-go"
+	"go.uber.org/zap"
+)
+
+// meiliRetriever stores the chunk documents in a MeiliSearch index and searches them with
+// its hybrid search.
+type meiliRetriever struct {
+	client meilisearch.ServiceManager
+	uid    string
+	dims   int
+	logger *zap.Logger
+
+	// Staging indexes of a rebuild queue their writes and await them all before the swap
+	async bool
+	tasks []int64
+}
+
+func newMeiliRetriever(client meilisearch.ServiceManager, uid string, dims int, logger *zap.Logger) *meiliRetriever {
+	return &meiliRetriever{
+		client: client,
+		uid:    uid,
+		dims:   dims,
+		logger: logger,
+	}
+}
+
+// EnsureIndex creates the index if it doesn't exist and applies the chunks settings.
+func (r *meiliRetriever) EnsureIndex(ctx context.Context) error {
+	// Create index if it doesn't exist
+	_, err := r.client.CreateIndex(&meilisearch.IndexConfig{
+		Uid:        r.uid,
+		PrimaryKey: "id",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+
+	index := r.client.Index(r.uid)
+
+	// Configure searchable attributes
+	searchableAttrs := []string{"content"}
+	_, err = index.UpdateSearchableAttributes(&searchableAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to update searchable attributes: %w", err)
+	}
+
+	// Configure filterable attributes (groupId is the distinct attribute collapsing duplicates)
+	filterableAttrs := []interface{}{"channelId", "created", "updated", "groupId"}
+	_, err = index.UpdateFilterableAttributes(&filterableAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to update filterable attributes: %w", err)
+	}
+
+	// Configure sortable attributes
+	sortableAttrs := []string{"created", "updated"}
+	_, err = index.UpdateSortableAttributes(&sortableAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to update sortable attributes: %w", err)
+	}
+
+	// Configure embedders for vector search
+	embedders := map[string]meilisearch.Embedder{
+		vectorDefault: {
+			Source:     meilisearch.UserProvidedEmbedderSource,
+			Dimensions: r.dims,
+		},
+		vectorContent: {
+			Source:     meilisearch.UserProvidedEmbedderSource,
+			Dimensions: r.dims,
+		},
+	}
+	_, err = index.UpdateEmbedders(embedders)
+	if err != nil {
+		return fmt.Errorf("failed to update embedders: %w", err)
+	}
+
+	r.logger.Info("MeiliSearch index configured", zap.String("index", r.uid))
+	return nil
+}
+
+// Upsert adds or replaces documents.
+func (r *meiliRetriever) Upsert(ctx context.Context, docs []ChunkDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	primaryKey := "id"
+	task, err := r.client.Index(r.uid).AddDocuments(docs, &meilisearch.DocumentOptions{PrimaryKey: &primaryKey})
+	if err == nil {
+		err = r.await(ctx, task.TaskUID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to index in MeiliSearch: %w", err)
+	}
+	return nil
+}
+
+// Delete removes documents by chunk ID.
+func (r *meiliRetriever) Delete(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	task, err := r.client.Index(r.uid).DeleteDocuments(ids, nil)
+	if err == nil {
+		err = r.await(ctx, task.TaskUID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete from MeiliSearch: %w", err)
+	}
+	return nil
+}
+
+// Search runs a MeiliSearch hybrid search, keeping the best hit of each duplicate group.
+func (r *meiliRetriever) Search(ctx context.Context, query string, vector []float32, limit int) ([]ChunkDocument, error) {
+	res, err := r.client.Index(r.uid).SearchWithContext(ctx, query, &meilisearch.SearchRequest{
+		Limit: int64(limit),
+		Hybrid: &meilisearch.SearchRequestHybrid{
+			SemanticRatio: semanticRatio,
+			Embedder:      vectorDefault,
+		},
+		Vector:                vector,
+		ShowRankingScore:      true,
+		RankingScoreThreshold: 0.5,
+		Distinct:              "groupId",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	return r.decodeHits(res.Hits), nil
+}
+
+// Nearest runs a purely semantic search on the content vectors.
+func (r *meiliRetriever) Nearest(ctx context.Context, vector []float32, limit int) ([]ChunkDocument, error) {
+	res, err := r.client.Index(r.uid).SearchWithContext(ctx, "", &meilisearch.SearchRequest{
+		Limit: int64(limit),
+		Hybrid: &meilisearch.SearchRequestHybrid{
+			SemanticRatio: 1,
+			Embedder:      vectorContent,
+		},
+		Vector:          vector,
+		RetrieveVectors: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to search similar chunks: %w", err)
+	}
+	return r.decodeHits(res.Hits), nil
+}
+
+// Documents returns the given documents, none if the index doesn't exist yet.
+func (r *meiliRetriever) Documents(ctx context.Context, ids []string) ([]ChunkDocument, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	var res meilisearch.DocumentsResult
+	err := r.client.Index(r.uid).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+		Ids:             ids,
+		Limit:           int64(len(ids)),
+		RetrieveVectors: true,
+	}, &res)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read documents: %w", err)
+	}
+	return r.decodeHits(res.Results), nil
+}
+
+// Updated lists the updated timestamps of the documents, a page at a time.
+func (r *meiliRetriever) Updated(ctx context.Context) (map[string]time.Time, error) {
+	index := r.client.Index(r.uid)
+	documents := make(map[string]time.Time)
+
+	for offset := int64(0); ; offset += reconcilePageSize {
+		var res meilisearch.DocumentsResult
+		err := index.GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+			Offset: offset,
+			Limit:  reconcilePageSize,
+			Fields: []string{"id", "updated"},
+		}, &res)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list documents: %w", err)
+		}
+
+		for _, hit := range res.Results {
+			var doc struct {
+				ID      string    `json:"id"`
+				Updated time.Time `json:"updated"`
+			}
+			if err := hit.DecodeInto(&doc); err != nil {
+				continue
+			}
+			documents[doc.ID] = doc.Updated
+		}
+
+		if len(res.Results) < reconcilePageSize {
+			return documents, nil
+		}
+	}
+}
+
+// Rebuild fills the index with the given UID, swaps it with the live index and deletes
+// the previous documents.
+func (r *meiliRetriever) Rebuild(ctx context.Context, name string, fill func(staging Retriever) error) error {
+	staging := newMeiliRetriever(r.client, name, r.dims, r.logger)
+	staging.async = true
+
+	fail := func(err error) error {
+		if _, delErr := r.client.DeleteIndex(name); delErr != nil {
+			r.logger.Warn("Failed to delete the unfinished index", zap.String("index", name), zap.Error(delErr))
+		}
+		return err
+	}
+
+	// The swap needs both indexes
+	if err := r.EnsureIndex(ctx); err != nil {
+		return fail(err)
+	}
+	if err := staging.EnsureIndex(ctx); err != nil {
+		return fail(err)
+	}
+
+	if err := fill(staging); err != nil {
+		return fail(err)
+	}
+	for _, taskUID := range staging.tasks {
+		if err := r.waitForTask(ctx, taskUID); err != nil {
+			return fail(fmt.Errorf("failed to fill index %s: %w", name, err))
+		}
+	}
+
+	// Swap atomically: the new documents take the live UID, the old ones the temporary UID
+	task, err := r.client.SwapIndexes([]*meilisearch.SwapIndexesParams{{Indexes: []string{r.uid, name}}})
+	if err == nil {
+		err = r.waitForTask(ctx, task.TaskUID)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("failed to swap indexes: %w", err))
+	}
+
+	if _, err := r.client.DeleteIndex(name); err != nil {
+		r.logger.Warn("Failed to delete the previous index", zap.String("index", name), zap.Error(err))
+	}
+	return nil
+}
+
+// await waits for a task, or queues it on a staging index.
+func (r *meiliRetriever) await(ctx context.Context, taskUID int64) error {
+	if r.async {
+		r.tasks = append(r.tasks, taskUID)
+		return nil
+	}
+	return r.waitForTask(ctx, taskUID)
+}
+
+// waitForTask blocks until the task is finished and returns an error if it failed.
+func (r *meiliRetriever) waitForTask(_ context.Context, taskUID int64) error {
+	task, err := r.client.WaitForTask(taskUID, time.Second*10)
+	if err != nil {
+		return err
+	}
+	if task.Status == meilisearch.TaskStatusFailed {
+		return fmt.Errorf("meilisearch task failed: %s (code: %s, type: %s)", task.Error.Message, task.Error.Code, task.Error.Type)
+	}
+	return nil
+}
+
+// decodeHits converts hits to documents. Retrieved vectors come in the
+// {"default": {"embeddings": [[...]]}} form of user-provided embedders.
+func (r *meiliRetriever) decodeHits(hits []meilisearch.Hit) []ChunkDocument {
+	docs := make([]ChunkDocument, 0, len(hits))
+	for _, hit := range hits {
+		raw := hit["_vectors"]
+		delete(hit, "_vectors")
+
+		var doc ChunkDocument
+		if err := hit.DecodeInto(&doc); err != nil {
+			r.logger.Warn("Failed to decode hit", zap.Error(err))
+			continue
+		}
+
+		var vectors map[string]struct {
+			Embeddings [][]float32 `json:"embeddings"`
+		}
+		if len(raw) > 0 && json.Unmarshal(raw, &vectors) == nil {
+			for name, vector := range vectors {
+				if len(vector.Embeddings) > 0 {
+					if doc.Vectors == nil {
+						doc.Vectors = make(map[string][]float32, len(vectors))
+					}
+					doc.Vectors[name] = vector.Embeddings[0]
+				}
+			}
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// isNotFound tells whether a MeiliSearch error is a 404, e.g. a missing index.
+func isNotFound(err error) bool {
+	var apiErr *meilisearch.Error
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
@@ -0,0 +1,337 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"go.uber.org/zap"
+)
+
+const outboxCollection = "index_outbox"
+
+// Outbox operations: what the chunk write meant for the index when it was recorded.
+const (
+	OutboxUpsert = "upsert"
+	OutboxDelete = "delete"
+)
+
+// Outbox entry statuses.
+const (
+	OutboxPending = "pending"
+	OutboxDone    = "done"
+	OutboxFailed  = "failed" // the chunk could not be synced on its own; the reconciler repairs it
+)
+
+const (
+	outboxPageSize     = 200
+	outboxPollInterval = 5 * time.Second
+	outboxMaxBackoff   = 5 * time.Minute // max wait between attempts while the index is down
+	outboxRetention    = 24 * time.Hour  // done entries are kept this long
+)
+
+// bindOutboxHooks records an outbox entry for every write of a chunk, in the transaction of
+// the write, so the index follows PocketBase across crashes, index outages and admin
+// edits in the dashboard.
+func (s *Service) bindOutboxHooks() {
+	s.app.OnRecordCreateExecute("chunks").BindFunc(s.writeOutbox(false))
+	s.app.OnRecordUpdateExecute("chunks").BindFunc(s.writeOutbox(false))
+	s.app.OnRecordDeleteExecute("chunks").BindFunc(s.writeOutbox(true))
+
+	// Entries are visible to the dispatcher once the write is committed
+	wake := func(e *core.RecordEvent) error {
+		s.wakeOutbox()
+		return e.Next()
+	}
+	s.app.OnRecordAfterCreateSuccess("chunks").BindFunc(wake)
+	s.app.OnRecordAfterUpdateSuccess("chunks").BindFunc(wake)
+	s.app.OnRecordAfterDeleteSuccess("chunks").BindFunc(wake)
+}
+
+// writeOutbox returns a hook running the chunk write and its outbox entry in one transaction.
+func (s *Service) writeOutbox(deleted bool) func(e *core.RecordEvent) error {
+	return func(e *core.RecordEvent) error {
+		original := e.App
+		err := e.App.RunInTransaction(func(txApp core.App) error {
+			e.App = txApp
+			if err := e.Next(); err != nil {
+				return err
+			}
+			return s.enqueueOutbox(txApp, e.Record, deleted)
+		})
+		e.App = original
+		return err
+	}
+}
+
+// enqueueOutbox saves the outbox entry of a chunk write, with the embeddings the indexer
+// computed for it, if any.
+func (s *Service) enqueueOutbox(app core.App, record *core.Record, deleted bool) error {
+	collection, err := app.FindCachedCollectionByNameOrId(outboxCollection)
+	if err != nil {
+		return fmt.Errorf("index_outbox collection not found: %w", err)
+	}
+
+	entry := core.NewRecord(collection)
+	entry.Set("chunk", record.Id)
+	entry.Set("status", OutboxPending)
+	if deleted || !isPublished(record) {
+		entry.Set("op", OutboxDelete)
+	} else {
+		entry.Set("op", OutboxUpsert)
+		if value, ok := s.vectors.Load(record); ok {
+			vectors := value.(chunkVectors)
+			entry.Set("vector", vectors.search)
+			entry.Set("contentVector", vectors.content)
+			entry.Set("contentHash", contentHash(record.GetString("content")))
+		}
+	}
+
+	if err := app.Save(entry); err != nil {
+		return fmt.Errorf("failed to save outbox entry: %w", err)
+	}
+	return nil
+}
+
+// wakeOutbox asks the dispatcher to look for new entries.
+func (s *Service) wakeOutbox() {
+	select {
+	case s.outboxWake <- struct{}{}:
+	default:
+	}
+}
+
+// RunOutbox applies the outbox to the index until ctx is cancelled: after chunk writes,
+// every outboxPollInterval for entries of other processes (e.g. a backfill command) and
+// after failures, with a backoff. Done entries are pruned hourly.
+func (s *Service) RunOutbox(ctx context.Context) {
+	prune := time.NewTicker(time.Hour)
+	defer prune.Stop()
+
+	for {
+		if err := s.dispatchOutbox(ctx); err != nil {
+			s.logger.Warn("Failed to apply outbox, retrying", zap.Error(err))
+		}
+
+		select {
+		case <-s.outboxWake:
+		case <-time.After(outboxPollInterval):
+		case <-prune.C:
+			s.pruneOutbox()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// flushOutbox applies the pending entries now, so the index reflects a write before the
+// indexer returns. Entries left pending are retried by RunOutbox.
+func (s *Service) flushOutbox(ctx context.Context) {
+	if err := s.dispatchOutbox(ctx); err != nil {
+		s.logger.Warn("Failed to apply outbox, left for the dispatcher", zap.Error(err))
+	}
+}
+
+// dispatchOutbox applies the pending entries, a page at a time in the order they were
+// written, and marks them done. After a failure (e.g. the index is down), dispatches are
+// skipped for a delay doubling from outboxPollInterval up to outboxMaxBackoff.
+func (s *Service) dispatchOutbox(ctx context.Context) error {
+	s.outboxMu.Lock()
+	defer s.outboxMu.Unlock()
+
+	if time.Now().Before(s.outboxRetryAt) {
+		return nil
+	}
+
+	err := s.dispatchPages(ctx)
+	if err != nil {
+		s.outboxFailures++
+		s.outboxRetryAt = time.Now().Add(outboxBackoff(s.outboxFailures))
+	} else {
+		s.outboxFailures = 0
+		s.outboxRetryAt = time.Time{}
+	}
+	return err
+}
+
+// outboxBackoff returns the delay before the next dispatch after the given number of
+// consecutive failures.
+func outboxBackoff(failures int) time.Duration {
+	delay := outboxPollInterval
+	for i := 1; i < failures && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxBackoff)
+}
+
+// dispatchPages applies the pending entries a page at a time, until a page fails.
+func (s *Service) dispatchPages(ctx context.Context) error {
+	for {
+		var entries []*core.Record
+		err := s.app.RecordQuery(outboxCollection).
+			AndWhere(dbx.HashExp{"status": OutboxPending}).
+			OrderBy("[[rowid]]").
+			Limit(outboxPageSize).
+			All(&entries)
+		if err != nil {
+			return fmt.Errorf("failed to load outbox: %w", err)
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+
+		if err := s.dispatchEntries(ctx, entries); err != nil {
+			return err
+		}
+		if len(entries) < outboxPageSize {
+			return nil
+		}
+	}
+}
+
+// dispatchEntries syncs the chunks of a page of entries. The entries only say which chunks
+// changed: each chunk is synced as it is now, so replaying an entry is harmless and the
+// last write wins. A chunk that fails alone is marked failed; if all fail (the index
+// is down) the entries stay pending, with their attempts counted.
+func (s *Service) dispatchEntries(ctx context.Context, entries []*core.Record) error {
+	// Keep the latest embeddings computed for each chunk
+	var ids []string
+	known := make(map[string]chunkVectors)
+	hashes := make(map[string]string)
+	for _, entry := range entries {
+		id := entry.GetString("chunk")
+		if _, ok := hashes[id]; !ok {
+			ids = append(ids, id)
+			hashes[id] = ""
+		}
+
+		var vectors chunkVectors
+		if err := entry.UnmarshalJSONField("vector", &vectors.search); err == nil && len(vectors.search) > 0 {
+			_ = entry.UnmarshalJSONField("contentVector", &vectors.content)
+			known[id] = vectors
+			hashes[id] = entry.GetString("contentHash")
+		}
+	}
+
+	records, err := s.app.FindRecordsByIds("chunks", ids)
+	if err != nil {
+		return fmt.Errorf("failed to load chunks: %w", err)
+	}
+	chunks := make(map[string]*core.Record, len(records))
+	for _, record := range records {
+		chunks[record.Id] = record
+		// The embedding is stale if the content was edited since
+		if hashes[record.Id] != contentHash(record.GetString("content")) {
+			delete(known, record.Id)
+		}
+	}
+
+	errs := make(map[string]error)
+	if err := s.syncChunks(ctx, ids, chunks, known); err != nil {
+		if len(ids) == 1 {
+			errs[ids[0]] = err
+		} else {
+			s.logger.Warn("Outbox page failed, retrying chunk by chunk", zap.Int("chunks", len(ids)), zap.Error(err))
+			for _, id := range ids {
+				if err := s.syncChunks(ctx, []string{id}, chunks, known); err != nil {
+					errs[id] = err
+				}
+			}
+		}
+	}
+
+	if len(errs) == len(ids) {
+		if err := s.markOutbox(entries, OutboxPending, errs[ids[0]].Error()); err != nil {
+			s.logger.Error("Failed to save outbox attempts", zap.Error(err))
+		}
+		return errs[ids[0]]
+	}
+
+	var done []*core.Record
+	failed := make(map[string][]*core.Record)
+	for _, entry := range entries {
+		if id := entry.GetString("chunk"); errs[id] != nil {
+			failed[id] = append(failed[id], entry)
+		} else {
+			done = append(done, entry)
+		}
+	}
+
+	if err := s.markOutbox(done, OutboxDone, ""); err != nil {
+		return err
+	}
+	for id, entries := range failed {
+		s.logger.Error("Failed to sync chunk to the index", zap.String("id", id), zap.Error(errs[id]))
+		if err := s.markOutbox(entries, OutboxFailed, errs[id].Error()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// markOutbox sets the status of dispatched entries. An error message counts as a failed attempt.
+func (s *Service) markOutbox(entries []*core.Record, status, message string) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	params := dbx.Params{
+		"status":  status,
+		"error":   message,
+		"updated": types.NowDateTime().String(),
+	}
+	if message != "" {
+		params["attempts"] = dbx.NewExp("[[attempts]] + 1")
+	}
+	_, err := s.app.DB().Update(outboxCollection, params, dbx.In("id", recordIDs(entries)...)).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to mark outbox entries %s: %w", status, err)
+	}
+	return nil
+}
+
+// syncChunks indexes the given chunks that are published and deletes the documents of the others.
+func (s *Service) syncChunks(ctx context.Context, ids []string, chunks map[string]*core.Record, known map[string]chunkVectors) error {
+	var published []*core.Record
+	var removed []string
+	for _, id := range ids {
+		if record, ok := chunks[id]; ok && isPublished(record) {
+			published = append(published, record)
+		} else {
+			removed = append(removed, id)
+		}
+	}
+
+	docs, _, err := s.chunkDocuments(ctx, published, known, false)
+	if err != nil {
+		return err
+	}
+	if err := s.retriever.Upsert(ctx, docs); err != nil {
+		return err
+	}
+	return s.retriever.Delete(ctx, removed)
+}
+
+// pruneOutbox deletes the entries done for longer than outboxRetention.
+func (s *Service) pruneOutbox() {
+	cutoff := types.NowDateTime().Add(-outboxRetention).String()
+	_, err := s.app.DB().Delete(outboxCollection, dbx.And(
+		dbx.HashExp{"status": OutboxDone},
+		dbx.NewExp("[[updated]] < {:cutoff}", dbx.Params{"cutoff": cutoff}),
+	)).Execute()
+	if err != nil {
+		s.logger.Error("Failed to prune outbox", zap.Error(err))
+	}
+}
+
+// recordIDs returns the IDs of records, for dbx.In.
+func recordIDs(records []*core.Record) []interface{} {
+	ids := make([]interface{}, len(records))
+	for i, record := range records {
+		ids[i] = record.Id
+	}
+	return ids
+}
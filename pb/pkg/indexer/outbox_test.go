@@ -0,0 +1,26 @@
+package indexer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 1, want: outboxPollInterval},
+		{failures: 2, want: 2 * outboxPollInterval},
+		{failures: 4, want: 8 * outboxPollInterval},
+		{failures: 6, want: 32 * outboxPollInterval},
+		{failures: 7, want: outboxMaxBackoff},
+		{failures: 100, want: outboxMaxBackoff},
+	}
+
+	for _, tt := range tests {
+		if got := outboxBackoff(tt.failures); got != tt.want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"svpb-tmpl/pkg/filter"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// Long messages are indexed as several chunks ("parts") sharing the message's channelId,
+// msgId and link, ordered by position. The first part (the head) also holds the raw
+// message and meta, and is the chunk FindChunk returns. The meta of the other parts holds
+// how each joins the previous one.
+
+// split splits a message text into its parts.
+func (s *Service) split(text string) []textPart {
+	return splitText(text, s.chunkMaxTokens, s.chunkOverlap)
+}
+
+// findParts returns the head chunk followed by the other parts of its message, in order.
+func (s *Service) findParts(head *core.Record) ([]*core.Record, error) {
+	if head.GetInt("msgId") == 0 {
+		return []*core.Record{head}, nil
+	}
+
+	rest, err := s.app.FindRecordsByFilter("chunks", "channelId = {:channelId} && msgId = {:msgId} && position > 0", "position", 0, 0, dbx.Params{
+		"channelId": head.GetString("channelId"),
+		"msgId":     head.GetInt("msgId"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find parts of chunk %s: %w", head.Id, err)
+	}
+
+	return append([]*core.Record{head}, rest...), nil
+}
+
+// replaceParts publishes the parts of a message text into the given records, reusing them
+// in order (so citations of the head keep resolving), creating records for new parts and
+// deleting the records of parts that no longer exist. Returns the filter verdict.
+func (s *Service) replaceParts(ctx context.Context, text string, records []*core.Record, parts []textPart) (filter.Verdict, error) {
+	updated := make([]*core.Record, len(parts))
+	for i, part := range parts {
+		var record *core.Record
+		if i < len(records) {
+			record = records[i]
+		} else {
+			record = newPartRecord(records[0])
+		}
+		record.Set("content", part.text)
+		record.Set("position", i)
+		if i > 0 {
+			record.Set("meta", map[string]interface{}{"join": part.join})
+		}
+		updated[i] = record
+	}
+
+	verdict, err := s.publish(ctx, text, updated)
+	if err != nil {
+		return verdict, err
+	}
+
+	if len(records) > len(parts) {
+		return verdict, s.deleteParts(ctx, records[len(parts):])
+	}
+	return verdict, nil
+}
+
+// deleteParts removes the chunks of parts cut from an edited message.
+func (s *Service) deleteParts(ctx context.Context, records []*core.Record) error {
+	for _, record := range records {
+		if err := s.app.Delete(record); err != nil {
+			return fmt.Errorf("failed to delete part %s: %w", record.Id, err)
+		}
+	}
+
+	s.flushOutbox(ctx)
+	return nil
+}
+
+// newPartRecord builds an unsaved chunks record for another part of the head's message.
+func newPartRecord(head *core.Record) *core.Record {
+	record := core.NewRecord(head.Collection())
+	for _, field := range []string{"channelId", "link", "msgId", "peerType"} {
+		record.Set(field, head.Get(field))
+	}
+	return record
+}
+
+// sameParts reports whether the records already hold the given parts.
+func sameParts(records []*core.Record, parts []textPart) bool {
+	if len(records) != len(parts) {
+		return false
+	}
+	for i, record := range records {
+		if record.GetString("content") != parts[i].text {
+			return false
+		}
+	}
+	return true
+}
+
+// joinOf returns how a part joins the previous part of its message, or nil if unknown.
+func joinOf(record *core.Record) *partJoin {
+	var meta struct {
+		Join *partJoin `json:"join"`
+	}
+	if err := record.UnmarshalJSONField("meta", &meta); err != nil {
+		return nil
+	}
+	return meta.Join
+}
+
+// MessageText returns the whole text of the message a chunk belongs to, reassembled from its parts.
+func MessageText(app core.App, chunk *core.Record) (string, error) {
+	if chunk.GetInt("msgId") == 0 {
+		return chunk.GetString("content"), nil
+	}
+
+	records, err := app.FindRecordsByFilter("chunks", "channelId = {:channelId} && msgId = {:msgId}", "position", 0, 0, dbx.Params{
+		"channelId": chunk.GetString("channelId"),
+		"msgId":     chunk.GetInt("msgId"),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to find parts of chunk %s: %w", chunk.Id, err)
+	}
+	if len(records) == 0 {
+		return chunk.GetString("content"), nil
+	}
+
+	text := records[0].GetString("content")
+	for _, record := range records[1:] {
+		text = joinParts(text, record.GetString("content"), joinOf(record))
+	}
+	return text, nil
+}
+
+// ExpandNeighbours merges the hits on parts of the same long message into its first hit,
+// whose content becomes the retrieved parts together with their neighbouring parts, so
+// the context is not cut mid-thought. Gaps between non-adjacent parts are marked with "[…]".
+func (s *Service) ExpandNeighbours(docs []ChunkDocument) []ChunkDocument {
+	type message struct {
+		index     int
+		positions map[int]bool
+	}
+
+	out := make([]ChunkDocument, 0, len(docs))
+	messages := make(map[string]*message)
+	var order []*message
+	for _, doc := range docs {
+		if doc.MsgID == 0 {
+			out = append(out, doc)
+			continue
+		}
+
+		key := fmt.Sprintf("%s/%d", doc.ChannelID, doc.MsgID)
+		m, ok := messages[key]
+		if !ok {
+			m = &message{index: len(out), positions: make(map[int]bool)}
+			messages[key] = m
+			order = append(order, m)
+			out = append(out, doc)
+		} else {
+			out[m.index].Links = appendMissing(out[m.index].Links, doc.Links...)
+		}
+		m.positions[doc.Position] = true
+	}
+
+	for _, m := range order {
+		doc := &out[m.index]
+
+		wanted := make([]interface{}, 0, len(m.positions)*3)
+		for position := range m.positions {
+			for p := position - 1; p <= position+1; p++ {
+				if p >= 0 {
+					wanted = append(wanted, p)
+				}
+			}
+		}
+
+		records, err := s.app.FindAllRecords("chunks",
+			dbx.HashExp{"channelId": doc.ChannelID, "msgId": doc.MsgID, "deleted": false},
+			dbx.In("position", wanted...),
+			dbx.Or(dbx.Not(dbx.HashExp{"verdict": VerdictRejected}), dbx.HashExp{"override": true}),
+		)
+		if err != nil {
+			s.logger.Warn("Failed to load neighbouring parts", zap.String("id", doc.ID), zap.Error(err))
+			continue
+		}
+		if len(records) < 2 {
+			continue
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].GetInt("position") < records[j].GetInt("position") })
+
+		runs := []string{records[0].GetString("content")}
+		for i := 1; i < len(records); i++ {
+			content := records[i].GetString("content")
+			if records[i].GetInt("position") == records[i-1].GetInt("position")+1 {
+				runs[len(runs)-1] = joinParts(runs[len(runs)-1], content, joinOf(records[i]))
+			} else {
+				runs = append(runs, content)
+			}
+		}
+		doc.Content = strings.Join(runs, "\n\n[…]\n\n")
+	}
+
+	return out
+}
+
+func appendMissing(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if existing == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
@@ -0,0 +1,25 @@
+package indexer
+
+import (
+	"svpb-tmpl/pkg/markdown"
+
+	"github.com/gotd/td/tg"
+)
+
+// Post is a Telegram message prepared for indexing.
+type Post struct {
+	ChannelID int64
+	Message   *tg.Message
+	Text      string                 // Text to index: the message text plus any extracted attachment text
+	Meta      map[string]interface{} // Extra data stored in chunks.meta
+}
+
+// NewPost creates a post that indexes the message text, with its formatting
+// (hidden links, code, emphasis) preserved as markdown.
+func NewPost(msg *tg.Message, channelID int64) Post {
+	return Post{
+		ChannelID: channelID,
+		Message:   msg,
+		Text:      markdown.FromTelegram(msg.Message, msg.Entities),
+	}
+}
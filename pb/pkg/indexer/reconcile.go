@@ -0,0 +1,164 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pocketbase/pocketbase/tools/types"
+	"go.uber.org/zap"
+)
+
+// reconcileGrace is how long a chunk may wait for its document before the reconciler
+// repairs it, so messages being indexed are left alone.
+const reconcileGrace = 2 * time.Minute
+
+// reconcilePageSize is the number of documents read or repaired at a time.
+const reconcilePageSize = 1000
+
+// ReconcileReport lists the differences between PocketBase and the search index found by a
+// reconciliation, by chunk ID. They are repaired unless it was a dry run.
+type ReconcileReport struct {
+	Chunks    int      `json:"chunks"`    // Published chunks in PocketBase
+	Documents int      `json:"documents"` // Documents in the index
+	Missing   []string `json:"missing"`   // Published chunks without a document: indexed
+	Stale     []string `json:"stale"`     // Documents older than their chunk: rewritten
+	Orphaned  []string `json:"orphaned"`  // Documents without a published chunk: deleted
+	Embedded  int      `json:"embedded"`  // Repaired chunks whose content had to be embedded
+	DryRun    bool     `json:"dryRun"`
+}
+
+// Differences is the number of differences found.
+func (r ReconcileReport) Differences() int {
+	return len(r.Missing) + len(r.Stale) + len(r.Orphaned)
+}
+
+// Reconcile diffs the IDs and updated timestamps of the published chunks against the
+// documents of the index and repairs the differences: chunks whose index write
+// failed are indexed, documents of chunks changed since are rewritten (their vectors
+// are kept if the content is the same) and documents of deleted or rejected chunks
+// are removed. With dryRun nothing is changed.
+func (s *Service) Reconcile(ctx context.Context, dryRun bool) (ReconcileReport, error) {
+	if !s.reconcileMu.TryLock() {
+		return ReconcileReport{}, fmt.Errorf("a reconciliation is already running")
+	}
+	defer s.reconcileMu.Unlock()
+
+	report := ReconcileReport{DryRun: dryRun}
+	start := time.Now()
+
+	// Documents are listed first: a chunk indexed in between is then only seen in
+	// PocketBase, within the grace period, rather than wrongly taken for an orphan
+	documents, err := s.retriever.Updated(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Documents = len(documents)
+
+	var chunks []struct {
+		ID      string         `db:"id"`
+		Updated types.DateTime `db:"updated"`
+	}
+	err = s.app.RecordQuery("chunks").
+		Select("id", "updated").
+		AndWhere(publishedChunks()).
+		All(&chunks)
+	if err != nil {
+		return report, fmt.Errorf("failed to load chunks: %w", err)
+	}
+	report.Chunks = len(chunks)
+
+	cutoff := time.Now().Add(-reconcileGrace)
+	published := make(map[string]bool, len(chunks))
+	for _, chunk := range chunks {
+		published[chunk.ID] = true
+
+		updated := chunk.Updated.Time()
+		if updated.After(cutoff) {
+			continue
+		}
+
+		indexed, ok := documents[chunk.ID]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, chunk.ID)
+		case updated.After(indexed):
+			report.Stale = append(report.Stale, chunk.ID)
+		}
+	}
+	for id := range documents {
+		if !published[id] {
+			report.Orphaned = append(report.Orphaned, id)
+		}
+	}
+
+	if !dryRun {
+		if err := s.repairDocuments(ctx, append(report.Missing, report.Stale...), &report); err != nil {
+			return report, err
+		}
+		if err := s.deleteDocuments(ctx, report.Orphaned); err != nil {
+			return report, err
+		}
+	}
+
+	s.logger.Info("Index reconciled",
+		zap.Int("chunks", report.Chunks),
+		zap.Int("documents", report.Documents),
+		zap.Int("missing", len(report.Missing)),
+		zap.Int("stale", len(report.Stale)),
+		zap.Int("orphaned", len(report.Orphaned)),
+		zap.Int("embedded", report.Embedded),
+		zap.Bool("dryRun", dryRun),
+		zap.Duration("took", time.Since(start)),
+	)
+	return report, nil
+}
+
+// repairDocuments writes the documents of the given chunks, a page at a time.
+func (s *Service) repairDocuments(ctx context.Context, ids []string, report *ReconcileReport) error {
+	for start := 0; start < len(ids); start += reconcilePageSize {
+		records, err := s.app.FindRecordsByIds("chunks", ids[start:min(start+reconcilePageSize, len(ids))])
+		if err != nil {
+			return fmt.Errorf("failed to load chunks: %w", err)
+		}
+
+		docs, embedded, err := s.chunkDocuments(ctx, records, nil, false)
+		if err != nil {
+			return err
+		}
+		report.Embedded += embedded
+
+		if err := s.retriever.Upsert(ctx, docs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteDocuments removes the given documents from the index, a page at a time.
+func (s *Service) deleteDocuments(ctx context.Context, ids []string) error {
+	for start := 0; start < len(ids); start += reconcilePageSize {
+		if err := s.retriever.Delete(ctx, ids[start:min(start+reconcilePageSize, len(ids))]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScheduleReconcile runs the reconciler with the app cron on the given schedule.
+func (s *Service) ScheduleReconcile(schedule string) error {
+	return s.app.Cron().Add("reconcileChunks", schedule, func() {
+		report, err := s.Reconcile(context.Background(), false)
+		if err != nil {
+			s.logger.Error("Index reconciliation failed", zap.Error(err))
+			return
+		}
+		if report.Differences() > 0 {
+			s.logger.Warn("Index was out of sync with PocketBase",
+				zap.Strings("missing", report.Missing),
+				zap.Strings("stale", report.Stale),
+				zap.Strings("orphaned", report.Orphaned),
+			)
+		}
+	})
+}
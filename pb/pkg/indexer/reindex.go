@@ -0,0 +1,376 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// ReindexOptions configures a rebuild of the chunks index.
+type ReindexOptions struct {
+	Embed    bool // Re-embed every chunk instead of copying the vectors of the current index
+	PageSize int  // Chunks read from PocketBase at a time
+}
+
+// ReindexProgress reports a rebuild of the chunks index.
+type ReindexProgress struct {
+	Index    string    `json:"index"`    // UID of the index being filled
+	Total    int       `json:"total"`    // Published chunks when the rebuild started
+	Done     int       `json:"done"`     // Chunks written to the new index, catch-up included
+	Embedded int       `json:"embedded"` // Chunks whose vectors were generated rather than copied
+	Running  bool      `json:"running"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// publishedChunks matches the chunks that belong in the search index.
+func publishedChunks() dbx.Expression {
+	return dbx.And(
+		dbx.HashExp{"deleted": false},
+		dbx.Or(dbx.Not(dbx.HashExp{"verdict": VerdictRejected}), dbx.HashExp{"override": true}),
+	)
+}
+
+// isPublished tells whether a chunk belongs in the search index (see publishedChunks).
+func isPublished(record *core.Record) bool {
+	return !record.GetBool("deleted") && (record.GetString("verdict") != VerdictRejected || record.GetBool("override"))
+}
+
+// Reindex rebuilds the chunks index from PocketBase: it streams the published chunks into
+// a fresh index, copying their vectors from the current index (or embedding them again
+// with the configured model if opts.Embed is set, or if the vector is missing or has other
+// dimensions), catches up with the chunks changed meanwhile and swaps the new index in.
+// Searches keep using the old index until the swap. The chunks changed during the catch-up
+// went to the old index, so they are written again to the new one once it is live.
+// report is called after every page.
+//
+// Chunks deleted in "delete" mode during the rebuild may linger in the new index.
+func (s *Service) Reindex(ctx context.Context, opts ReindexOptions, report func(ReindexProgress)) (ReindexProgress, error) {
+	if opts.PageSize <= 0 {
+		opts.PageSize = 500
+	}
+
+	started := time.Now().UTC()
+	progress := ReindexProgress{
+		Index:   fmt.Sprintf("%s_%d", IndexName, started.Unix()),
+		Running: true,
+		Started: started,
+	}
+	if report == nil {
+		report = func(ReindexProgress) {}
+	}
+
+	fail := func(err error) (ReindexProgress, error) {
+		progress.Running = false
+		progress.Error = err.Error()
+		progress.Finished = time.Now().UTC()
+		report(progress)
+		return progress, err
+	}
+
+	total, err := s.app.CountRecords("chunks", publishedChunks())
+	if err != nil {
+		return fail(fmt.Errorf("failed to count chunks: %w", err))
+	}
+	progress.Total = int(total)
+
+	s.logger.Info("Reindex started",
+		zap.String("index", progress.Index),
+		zap.Int("chunks", progress.Total),
+		zap.Bool("embed", opts.Embed),
+	)
+	report(progress)
+
+	// writer writes pages of chunks to an index, published ones as documents, others as deletions
+	writer := func(target Retriever) func([]*core.Record) error {
+		return func(records []*core.Record) error {
+			var published []*core.Record
+			var removed []string
+			for _, record := range records {
+				if isPublished(record) {
+					published = append(published, record)
+				} else {
+					removed = append(removed, record.Id)
+				}
+			}
+
+			// Vectors are read from the live index: without one every chunk is embedded
+			docs, embedded, err := s.chunkDocuments(ctx, published, nil, opts.Embed)
+			if err != nil {
+				return err
+			}
+			if err := target.Upsert(ctx, docs); err != nil {
+				return err
+			}
+			if err := target.Delete(ctx, removed); err != nil {
+				return err
+			}
+
+			progress.Embedded += embedded
+			progress.Done += len(published)
+			report(progress)
+			return nil
+		}
+	}
+	updatedSince := func(t time.Time) dbx.Expression {
+		return dbx.NewExp("[[updated]] >= {:since}", dbx.Params{"since": t.Format("2006-01-02 15:04:05.000Z")})
+	}
+
+	var catchUp time.Time
+	err = s.retriever.Rebuild(ctx, progress.Index, func(staging Retriever) error {
+		write := writer(staging)
+		if err := s.streamChunks(ctx, publishedChunks(), opts.PageSize, write); err != nil {
+			return err
+		}
+
+		// Catch up with the chunks saved (or tombstoned) while the index was being filled
+		catchUp = time.Now().UTC()
+		return s.streamChunks(ctx, updatedSince(started), opts.PageSize, write)
+	})
+
+	if err != nil {
+		return fail(err)
+	}
+
+	// Until the swap, the outbox applied the chunks saved during the catch-up to the old index
+	if err := s.streamChunks(ctx, updatedSince(catchUp), opts.PageSize, writer(s.retriever)); err != nil {
+		return fail(fmt.Errorf("failed to catch up after the swap: %w", err))
+	}
+
+	progress.Running = false
+	progress.Finished = time.Now().UTC()
+	report(progress)
+
+	s.logger.Info("Reindex finished",
+		zap.Int("chunks", progress.Done),
+		zap.Int("embedded", progress.Embedded),
+		zap.Duration("took", progress.Finished.Sub(started)),
+	)
+	return progress, nil
+}
+
+// streamChunks passes the chunks matching expr to fn, a page at a time in ID order.
+func (s *Service) streamChunks(ctx context.Context, expr dbx.Expression, pageSize int, fn func([]*core.Record) error) error {
+	after := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var records []*core.Record
+		err := s.app.RecordQuery("chunks").
+			AndWhere(expr).
+			AndWhere(dbx.NewExp("[[id]] > {:after}", dbx.Params{"after": after})).
+			OrderBy("id").
+			Limit(int64(pageSize)).
+			All(&records)
+		if err != nil {
+			return fmt.Errorf("failed to load chunks: %w", err)
+		}
+		if len(records) == 0 {
+			return nil
+		}
+
+		if err := fn(records); err != nil {
+			return err
+		}
+		after = records[len(records)-1].Id
+	}
+}
+
+// chunkDocuments builds the documents of published chunks. Vectors come from known, then
+// from the current index unless embed is set or the content changed since; the missing
+// ones are embedded. Returns how many chunks were embedded.
+func (s *Service) chunkDocuments(ctx context.Context, records []*core.Record, known map[string]chunkVectors, embed bool) ([]ChunkDocument, int, error) {
+	dims := s.embedder.Dimensions()
+	vectors := make(map[string]chunkVectors, len(records))
+	merge := func(id string, from chunkVectors) {
+		v := vectors[id]
+		if len(v.search) != dims && len(from.search) == dims {
+			v.search = from.search
+		}
+		if len(v.content) != dims && len(from.content) == dims {
+			v.content = from.content
+		}
+		vectors[id] = v
+	}
+	complete := func(id string) bool {
+		return len(vectors[id].search) == dims && len(vectors[id].content) == dims
+	}
+
+	var unknown []*core.Record
+	for _, record := range records {
+		merge(record.Id, known[record.Id])
+		if !complete(record.Id) {
+			unknown = append(unknown, record)
+		}
+	}
+
+	if !embed && len(unknown) > 0 {
+		current, err := s.currentVectors(ctx, unknown)
+		if err != nil {
+			return nil, 0, err
+		}
+		for id, v := range current {
+			merge(id, v)
+		}
+	}
+
+	var missing []*core.Record
+	for _, record := range unknown {
+		if !complete(record.Id) {
+			missing = append(missing, record)
+		}
+	}
+
+	// Parts are embedded with the context of their head chunk, as when they were indexed
+	heads := make(map[string]*core.Record)
+	for _, record := range records {
+		if record.GetInt("position") == 0 {
+			heads[record.GetString("channelId")+":"+strconv.Itoa(record.GetInt("msgId"))] = record
+		}
+	}
+
+	for start := 0; start < len(missing); start += s.batchSize {
+		batch := missing[start:min(start+s.batchSize, len(missing))]
+
+		// Only the missing vectors of each chunk are embedded
+		var inputs []string
+		for _, record := range batch {
+			content := record.GetString("content")
+			if len(vectors[record.Id].search) != dims {
+				head, err := s.headOf(record, heads)
+				if err != nil {
+					return nil, 0, err
+				}
+				inputs = append(inputs, s.embeddingInput(s.embeddingContext(head), content))
+			}
+			if len(vectors[record.Id].content) != dims {
+				inputs = append(inputs, content)
+			}
+		}
+
+		embedded, err := s.embedDistinct(ctx, inputs)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to generate embedding: %w", err)
+		}
+		for _, record := range batch {
+			v := vectors[record.Id]
+			if len(v.search) != dims {
+				v.search, embedded = embedded[0], embedded[1:]
+			}
+			if len(v.content) != dims {
+				v.content, embedded = embedded[0], embedded[1:]
+			}
+			vectors[record.Id] = v
+		}
+	}
+
+	docs := make([]ChunkDocument, len(records))
+	for i, record := range records {
+		docs[i] = newChunkDocument(record, vectors[record.Id])
+	}
+	return docs, len(missing), nil
+}
+
+// currentVectors returns the vectors of the chunks in the current index whose content
+// is unchanged, by chunk ID.
+func (s *Service) currentVectors(ctx context.Context, records []*core.Record) (map[string]chunkVectors, error) {
+	ids := make([]string, len(records))
+	for i, record := range records {
+		ids[i] = record.Id
+	}
+
+	docs, err := s.retriever.Documents(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current documents: %w", err)
+	}
+
+	contents := make(map[string]string, len(records))
+	for _, record := range records {
+		contents[record.Id] = record.GetString("content")
+	}
+
+	vectors := make(map[string]chunkVectors, len(docs))
+	for _, doc := range docs {
+		if doc.Content == contents[doc.ID] {
+			vectors[doc.ID] = doc.vectors()
+		}
+	}
+	return vectors, nil
+}
+
+// headOf returns the head chunk of a part, from heads if it is there.
+func (s *Service) headOf(record *core.Record, heads map[string]*core.Record) (*core.Record, error) {
+	if record.GetInt("position") == 0 {
+		return record, nil
+	}
+
+	key := record.GetString("channelId") + ":" + strconv.Itoa(record.GetInt("msgId"))
+	if head, ok := heads[key]; ok {
+		return head, nil
+	}
+
+	channelID, _ := strconv.ParseInt(record.GetString("channelId"), 10, 64)
+	head, err := s.FindChunk(channelID, record.GetInt("msgId"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find head of chunk %s: %w", record.Id, err)
+	}
+	if head == nil {
+		// Orphaned part: embed it on its own
+		head = record
+	}
+	heads[key] = head
+	return head, nil
+}
+
+// HandleReindex is an admin route that starts a rebuild of the chunks index in the
+// background ("embed" body field to re-embed every chunk) and returns its progress.
+func (s *Service) HandleReindex(e *core.RequestEvent) error {
+	var body struct {
+		Embed bool `json:"embed"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+
+	s.reindexMu.Lock()
+	if s.reindex.Running {
+		s.reindexMu.Unlock()
+		return e.Error(http.StatusConflict, "A reindex is already running", nil)
+	}
+	s.reindex = ReindexProgress{Running: true, Started: time.Now().UTC()}
+	s.reindexMu.Unlock()
+
+	go func() {
+		_, err := s.Reindex(context.Background(), ReindexOptions{Embed: body.Embed}, func(p ReindexProgress) {
+			s.reindexMu.Lock()
+			s.reindex = p
+			s.reindexMu.Unlock()
+		})
+		if err != nil {
+			s.logger.Error("Reindex failed", zap.Error(err))
+		}
+	}()
+
+	return e.JSON(http.StatusAccepted, s.ReindexStatus())
+}
+
+// HandleReindexStatus is an admin route returning the progress of the last rebuild.
+func (s *Service) HandleReindexStatus(e *core.RequestEvent) error {
+	return e.JSON(200, s.ReindexStatus())
+}
+
+// ReindexStatus returns the progress of the last rebuild started through the API.
+func (s *Service) ReindexStatus() ReindexProgress {
+	s.reindexMu.Lock()
+	defer s.reindexMu.Unlock()
+	return s.reindex
+}
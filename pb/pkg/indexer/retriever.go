@@ -0,0 +1,79 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"svpb-tmpl/pkg/config"
+
+	"github.com/meilisearch/meilisearch-go"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// Retrieval backends.
+const (
+	RetrieverMeili  = "meili"  // MeiliSearch hybrid search
+	RetrieverSQLite = "sqlite" // In-process: SQLite FTS5 and brute-force vector search, fused with RRF
+)
+
+// semanticRatio weighs vector similarity against keyword matching in hybrid search.
+const semanticRatio = 0.6
+
+// Names of the vectors of a document.
+const (
+	vectorDefault = "default" // the chunk rendered with the embedding template, searched by queries
+	vectorContent = "content" // the chunk content alone, compared by duplicate detection
+)
+
+// chunkVectors are the embeddings of a chunk. The embedding template adds the context of the
+// post (channel, date, ...), which differs between a post and its reposts, so duplicates are
+// compared on the content alone.
+type chunkVectors struct {
+	search  []float32
+	content []float32
+}
+
+// Retriever stores the documents of the published chunks and searches them.
+type Retriever interface {
+	// EnsureIndex creates the index if it doesn't exist and applies its settings.
+	EnsureIndex(ctx context.Context) error
+	// Upsert adds or replaces documents.
+	Upsert(ctx context.Context, docs []ChunkDocument) error
+	// Delete removes documents by chunk ID, ignoring unknown ones.
+	Delete(ctx context.Context, ids []string) error
+
+	// Search returns the best documents for a query and its embedding, keyword and
+	// semantic matches combined, one per duplicate group, with their ranking score.
+	Search(ctx context.Context, query string, vector []float32, limit int) ([]ChunkDocument, error)
+	// Nearest returns the documents whose content vectors are closest to a content vector,
+	// with their vectors.
+	Nearest(ctx context.Context, vector []float32, limit int) ([]ChunkDocument, error)
+	// Documents returns the stored documents with the given IDs, with their vectors.
+	Documents(ctx context.Context, ids []string) ([]ChunkDocument, error)
+	// Updated returns the updated time of every document, by chunk ID.
+	Updated(ctx context.Context) (map[string]time.Time, error)
+
+	// Rebuild fills a fresh index named name with fill, then atomically replaces the
+	// index with it. Searches keep using the current index meanwhile.
+	Rebuild(ctx context.Context, name string, fill func(staging Retriever) error) error
+}
+
+// newRetriever creates the retrieval backend selected in the config.
+func newRetriever(app core.App, cfg *config.Config, dims int, logger *zap.Logger) (Retriever, error) {
+	switch cfg.Retriever {
+	case RetrieverMeili, "":
+		client := meilisearch.New(cfg.MeiliHost, meilisearch.WithAPIKey(cfg.MeiliMasterKey))
+		return newMeiliRetriever(client, IndexName, dims, logger), nil
+	case RetrieverSQLite:
+		return newSQLiteRetriever(app, IndexName+"_index", logger), nil
+	default:
+		return nil, fmt.Errorf("unknown retriever: %s", cfg.Retriever)
+	}
+}
+
+// vectors returns the embeddings of a document.
+func (d ChunkDocument) vectors() chunkVectors {
+	return chunkVectors{search: d.Vectors[vectorDefault], content: d.Vectors[vectorContent]}
+}
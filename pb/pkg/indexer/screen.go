@@ -0,0 +1,143 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"svpb-tmpl/pkg/filter"
+
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+// Chunk verdicts.
+const (
+	VerdictAccepted = "accepted"
+	VerdictRejected = "rejected"
+)
+
+// SetFilter sets the spam filter run before chunks are indexed.
+func (s *Service) SetFilter(f filter.Filter) {
+	s.filter = f
+}
+
+// OnIndexed registers a callback run after a chunk is indexed or re-indexed,
+// with the chunk's embedding.
+func (s *Service) OnIndexed(f func(ctx context.Context, record *core.Record, embedding []float32)) {
+	s.onIndexed = append(s.onIndexed, f)
+}
+
+// BindHooks indexes rejected posts once an admin overrides the verdict of one of their chunks.
+func (s *Service) BindHooks() {
+	s.app.OnRecordAfterUpdateSuccess("chunks").BindFunc(func(e *core.RecordEvent) error {
+		record := e.Record
+		if record.GetBool("override") && record.GetString("verdict") == VerdictRejected && !record.GetBool("deleted") {
+			go func() {
+				if err := s.publishOverride(context.Background(), record); err != nil {
+					s.logger.Error("Failed to index overridden chunk", zap.String("id", record.Id), zap.Error(err))
+				}
+			}()
+		}
+		return e.Next()
+	})
+}
+
+// publishOverride publishes all the parts of the message of an overridden chunk.
+func (s *Service) publishOverride(ctx context.Context, record *core.Record) error {
+	head := record
+	if record.GetInt("position") > 0 {
+		channelID, _ := strconv.ParseInt(record.GetString("channelId"), 10, 64)
+		found, err := s.FindChunk(channelID, record.GetInt("msgId"))
+		if err != nil {
+			return fmt.Errorf("failed to look up head chunk: %w", err)
+		}
+		if found != nil {
+			head = found
+		}
+	}
+
+	records, err := s.findParts(head)
+	if err != nil {
+		return err
+	}
+	for i := range records {
+		if records[i].Id == record.Id {
+			records[i] = record
+		}
+	}
+
+	text, err := MessageText(s.app, head)
+	if err != nil {
+		return err
+	}
+	_, err = s.publish(ctx, text, records)
+	return err
+}
+
+// publish screens a message and saves its chunks. Accepted chunks are embedded and indexed for
+// MeiliSearch; rejected ones are kept in PocketBase only, with the verdict reason,
+// until an admin sets override. text is the whole message, screened once for all its chunks.
+// Returns the filter verdict.
+func (s *Service) publish(ctx context.Context, text string, records []*core.Record) (filter.Verdict, error) {
+	verdict := s.screen(ctx, text, records)
+	if verdict.Rejected {
+		// If an edit turned an indexed post into spam, the outbox removes it from the index
+		for _, record := range records {
+			record.Set("verdict", VerdictRejected)
+			record.Set("verdictReason", verdict.Reason)
+			if err := s.app.Save(record); err != nil {
+				return verdict, fmt.Errorf("failed to save to PocketBase: %w", err)
+			}
+		}
+		s.flushOutbox(ctx)
+
+		s.logger.Info("Post rejected by filter",
+			zap.String("id", records[0].Id),
+			zap.String("channelId", records[0].GetString("channelId")),
+			zap.Int("msgId", records[0].GetInt("msgId")),
+			zap.String("reason", verdict.Reason),
+		)
+		return verdict, nil
+	}
+
+	// Every part is embedded with the context of the whole post, in the next batch
+	embeddings, err := s.submit(ctx, records, s.embeddingContext(records[0]))
+	if err != nil {
+		return verdict, err
+	}
+
+	// Callbacks run once every part is saved, so they can reassemble the message
+	for i, record := range records {
+		for _, f := range s.onIndexed {
+			f(ctx, record, embeddings[i])
+		}
+	}
+
+	return verdict, nil
+}
+
+// screen runs the filter on the message text. Posts with a chunk overridden by an admin are
+// always accepted, and filter failures (e.g. the LLM being down) accept the post rather than
+// holding back ingestion.
+func (s *Service) screen(ctx context.Context, text string, records []*core.Record) filter.Verdict {
+	if s.filter == nil {
+		return filter.Verdict{}
+	}
+	for _, record := range records {
+		if record.GetBool("override") {
+			return filter.Verdict{}
+		}
+	}
+
+	verdict, err := s.filter.Check(ctx, text)
+	if err != nil {
+		s.logger.Warn("Spam filter failed, accepting post",
+			zap.String("channelId", records[0].GetString("channelId")),
+			zap.Int("msgId", records[0].GetInt("msgId")),
+			zap.Error(err),
+		)
+		return filter.Verdict{}
+	}
+	return verdict
+}
@@ -2,262 +2,496 @@ package indexer
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"text/template"
 	"time"
 
 	"svpb-tmpl/pkg/config"
+	"svpb-tmpl/pkg/embedding"
+	"svpb-tmpl/pkg/filter"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/gotd/td/tg"
-	"github.com/meilisearch/meilisearch-go"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
-	openai "github.com/sashabaranov/go-openai"
 	"go.uber.org/zap"
 )
 
-const (
-	IndexName      = "chunks"
-	EmbeddingModel = "voyage/voyage-3.5-lite"
-	EmbeddingDims  = 1024
-)
+const IndexName = "chunks"
 
-// ChunkDocument represents a document in the MeiliSearch index.
+// ChunkDocument represents a document in the chunks index.
 type ChunkDocument struct {
 	ID           string               `json:"id"`
 	Content      string               `json:"content"`
 	ChannelID    string               `json:"channelId"`
+	MsgID        int                  `json:"msgId"`
+	Position     int                  `json:"position"` // Part of a long message
 	Link         string               `json:"link"`
 	Created      time.Time            `json:"created"`
 	Updated      time.Time            `json:"updated"`
-	Vectors      map[string][]float32 `json:"_vectors"` // MeiliSearch 1.6+ expects a map if embedders are named
+	GroupID      string               `json:"groupId"`         // Canonical chunk of the duplicate group
+	Links        []string             `json:"links,omitempty"` // Links of the whole duplicate group, set by SearchHybrid
+	Vectors      map[string][]float32 `json:"_vectors"`        // MeiliSearch 1.6+ expects a map if embedders are named
 	RankingScore float64              `json:"_rankingScore,omitempty"`
 }
 
-// Service handles message indexing: embedding generation, PocketBase storage, and MeiliSearch sync.
+// Service handles message indexing: embedding generation, PocketBase storage, and search index sync.
 type Service struct {
-	app      core.App
-	meili    meilisearch.ServiceManager
-	openai   *openai.Client
-	logger   *zap.Logger
-	indexUID string
+	app       core.App
+	retriever Retriever
+	embedder  embedding.Embedder
+	cache     *embedding.Cache // nil if disabled
+	logger    *zap.Logger
+
+	deleteMode          string
+	duplicateSimilarity float64
+	chunkMaxTokens      int
+	chunkOverlap        int
+	usernames           sync.Map // channel ID -> public username, for citation links
+	titles              sync.Map // channel ID -> title, for the embedding template
+	template            *template.Template
+	filter              filter.Filter // spam filter, nil to accept everything
+	onIndexed           []func(ctx context.Context, record *core.Record, embedding []float32)
+
+	batch       chan *batchItem
+	batchOnce   sync.Once
+	batchSize   int           // max chunks per batch
+	batchWindow time.Duration // max wait for a batch to fill
+
+	reindexMu sync.Mutex
+	reindex   ReindexProgress // last rebuild started through the API
+
+	reconcileMu sync.Mutex // one reconciliation at a time
+
+	vectors    sync.Map // *core.Record -> chunkVectors being saved, for its outbox entry
+	outboxWake chan struct{}
+	outboxMu   sync.Mutex // one dispatcher at a time, guards the fields below
+
+	outboxFailures int       // consecutive failed dispatches
+	outboxRetryAt  time.Time // no dispatch before, while the index is failing
 }
 
 // NewService creates a new indexer service.
 func NewService(app core.App, cfg *config.Config, logger *zap.Logger) (*Service, error) {
-	// Initialize MeiliSearch client
-	meiliClient := meilisearch.New(cfg.MeiliHost, meilisearch.WithAPIKey(cfg.MeiliMasterKey))
+	if cfg.IndexBatchSize < 1 {
+		return nil, fmt.Errorf("invalid index batch size: %d", cfg.IndexBatchSize)
+	}
 
-	// Initialize OpenAI client
-	openaiConfig := openai.DefaultConfig(cfg.OpenAIAPIKey)
-	if cfg.OpenAIBaseURL != "" {
-		openaiConfig.BaseURL = cfg.OpenAIBaseURL
+	// Initialize the embedder selected in the config
+	embedder, err := embedding.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create embedder: %w", err)
+	}
+
+	// Initialize the search backend selected in the config
+	retriever, err := newRetriever(app, cfg, embedder.Dimensions(), logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create retriever: %w", err)
+	}
+
+	// Remember vectors so unchanged texts are not embedded again
+	var cache *embedding.Cache
+	if cfg.EmbeddingCacheSize > 0 {
+		cache = embedding.NewCache(app, embedder, cfg.EmbeddingCacheSize, cfg.EmbeddingCacheTTL, logger)
+		embedder = cache
+	}
+
+	tmpl, err := parseEmbeddingTemplate(cfg.EmbeddingTemplate)
+	if err != nil {
+		return nil, err
 	}
-	openaiClient := openai.NewClientWithConfig(openaiConfig)
 
 	svc := &Service{
-		app:      app,
-		meili:    meiliClient,
-		openai:   openaiClient,
-		logger:   logger,
-		indexUID: IndexName,
+		app:       app,
+		retriever: retriever,
+		embedder:  embedder,
+		cache:     cache,
+		logger:    logger,
+
+		deleteMode:          cfg.ChunkDeleteMode,
+		duplicateSimilarity: cfg.DuplicateSimilarity,
+		chunkMaxTokens:      cfg.ChunkMaxTokens,
+		chunkOverlap:        cfg.ChunkOverlapTokens,
+		template:            tmpl,
+
+		batch:       make(chan *batchItem),
+		batchSize:   cfg.IndexBatchSize,
+		batchWindow: cfg.IndexBatchWindow,
+
+		outboxWake: make(chan struct{}, 1),
 	}
 
+	// Bound here rather than in BindHooks: every process writing chunks (server, backfill,
+	// commands) must record their outbox entries
+	svc.bindOutboxHooks()
+
 	return svc, nil
 }
 
-// EnsureIndex creates or updates the MeiliSearch index with proper settings.
+// EnsureIndex creates or updates the chunks index with proper settings.
 func (s *Service) EnsureIndex(ctx context.Context) error {
-	// Create index if it doesn't exist
-	_, err := s.meili.CreateIndex(&meilisearch.IndexConfig{
-		Uid:        s.indexUID,
-		PrimaryKey: "id",
-	})
+	return s.retriever.EnsureIndex(ctx)
+}
+
+// IndexMessage processes a Telegram post: generates embeddings, saves to PocketBase, and indexes it for search.
+// Long posts are split into several chunks.
+func (s *Service) IndexMessage(ctx context.Context, post Post) error {
+	msg, channelID := post.Message, post.ChannelID
+	text := post.Text
+	if text == "" {
+		return nil
+	}
+
+	// Skip messages that were already indexed (e.g. seen by both the listener and a backfill)
+	existing, err := s.FindChunk(channelID, msg.ID)
 	if err != nil {
-		return fmt.Errorf("failed to create index: %w", err)
+		return fmt.Errorf("failed to look up existing chunk: %w", err)
+	}
+	if existing != nil {
+		s.logger.Debug("Message already indexed, skipping",
+			zap.String("id", existing.Id),
+			zap.Int64("channelId", channelID),
+			zap.Int("msgId", msg.ID),
+		)
+		return nil
 	}
 
-	index := s.meili.Index(s.indexUID)
+	// Build source link
+	link := s.messageLink(channelID, msg.ID)
 
-	// Configure searchable attributes
-	searchableAttrs := []string{"content"}
-	_, err = index.UpdateSearchableAttributes(&searchableAttrs)
+	record, err := s.newChunkRecord(post, link)
 	if err != nil {
-		return fmt.Errorf("failed to update searchable attributes: %w", err)
+		return err
 	}
 
-	// Configure filterable attributes
-	filterableAttrs := []interface{}{"channelId", "created", "updated"}
-	_, err = index.UpdateFilterableAttributes(&filterableAttrs)
+	// Split, screen, save and index
+	parts := s.split(text)
+	verdict, err := s.replaceParts(ctx, text, []*core.Record{record}, parts)
 	if err != nil {
-		return fmt.Errorf("failed to update filterable attributes: %w", err)
+		if isDuplicateChunk(err) {
+			// Indexed meanwhile by another worker (e.g. the listener racing a backfill)
+			s.logger.Debug("Message already indexed, skipping",
+				zap.Int64("channelId", channelID),
+				zap.Int("msgId", msg.ID),
+			)
+			return nil
+		}
+		return err
 	}
+	if verdict.Rejected {
+		return nil
+	}
+
+	s.logger.Info("Message indexed successfully",
+		zap.String("id", record.Id),
+		zap.Int64("channelId", channelID),
+		zap.Int("msgId", msg.ID),
+		zap.Int("parts", len(parts)),
+	)
 
-	// Configure sortable attributes
-	sortableAttrs := []string{"created", "updated"}
-	_, err = index.UpdateSortableAttributes(&sortableAttrs)
+	return nil
+}
+
+// UpdateMessage re-indexes an edited Telegram post in place: the existing chunks keep their
+// record IDs (so old citations still resolve) while their content and embeddings are regenerated.
+// Messages that were never indexed are indexed as new.
+func (s *Service) UpdateMessage(ctx context.Context, post Post) error {
+	msg, channelID := post.Message, post.ChannelID
+
+	record, err := s.FindChunk(channelID, msg.ID)
 	if err != nil {
-		return fmt.Errorf("failed to update sortable attributes: %w", err)
+		return fmt.Errorf("failed to look up existing chunk: %w", err)
+	}
+	if record == nil {
+		return s.IndexMessage(ctx, post)
 	}
 
-	// Configure embedders for vector search
-	embedders := map[string]meilisearch.Embedder{
-		"default": {
-			Source:     meilisearch.UserProvidedEmbedderSource,
-			Dimensions: EmbeddingDims,
-		},
+	records, err := s.findParts(record)
+	if err != nil {
+		return err
 	}
-	_, err = index.UpdateEmbedders(embedders)
+
+	text := post.Text
+	parts := s.split(text)
+	if text == "" || sameParts(records, parts) {
+		// Nothing to re-embed (e.g. only reactions or media changed)
+		return nil
+	}
+
+	// Screen, save and replace the indexed documents (same primary keys)
+	record.Set("raw", msg)
+	record.Set("meta", post.Meta)
+	verdict, err := s.replaceParts(ctx, text, records, parts)
 	if err != nil {
-		return fmt.Errorf("failed to update embedders: %w", err)
+		return err
+	}
+	if verdict.Rejected {
+		return nil
 	}
 
-	s.logger.Info("MeiliSearch index configured", zap.String("index", s.indexUID))
+	s.logger.Info("Message re-indexed after edit",
+		zap.String("id", record.Id),
+		zap.Int64("channelId", channelID),
+		zap.Int("msgId", msg.ID),
+		zap.Int("parts", len(parts)),
+	)
+
 	return nil
 }
 
-// IndexMessage processes a Telegram message: generates embedding, saves to PocketBase, and indexes in MeiliSearch.
-func (s *Service) IndexMessage(ctx context.Context, msg *tg.Message, channelID int64) error {
-	text := msg.Message
-	if text == "" {
+// DeleteMessages removes the chunks of deleted Telegram messages from PocketBase and the search index.
+// In tombstone mode the PocketBase records are kept and flagged as deleted instead.
+// A zero channelID matches messages from legacy groups and private chats, whose
+// deletion updates carry no peer (their message IDs are unique per account).
+func (s *Service) DeleteMessages(ctx context.Context, channelID int64, msgIDs []int) error {
+	if len(msgIDs) == 0 {
 		return nil
 	}
 
-	// Generate embedding
-	embedding, err := s.generateEmbedding(ctx, text)
-	if err != nil {
-		return fmt.Errorf("failed to generate embedding: %w", err)
+	ids := make([]interface{}, len(msgIDs))
+	for i, id := range msgIDs {
+		ids[i] = id
 	}
 
-	// Build source link
-	link := fmt.Sprintf("https://t.me/c/%d/%d", channelID, msg.ID)
+	peerExpr := dbx.HashExp{"channelId": fmt.Sprintf("%d", channelID)}
+	if channelID == 0 {
+		peerExpr = dbx.HashExp{"peerType": []interface{}{"chat", "user"}}
+	}
+
+	records, err := s.app.FindAllRecords("chunks", peerExpr, dbx.In("msgId", ids...))
+	if err != nil {
+		return fmt.Errorf("failed to find chunks: %w", err)
+	}
+	if len(records) == 0 {
+		return nil
+	}
 
-	// Save to PocketBase
-	record, err := s.saveToPocketBase(ctx, text, channelID, link, msg)
+	// Deleted messages are never searchable, whatever the mode: the outbox removes them from the index
+	err = s.app.RunInTransaction(func(txApp core.App) error {
+		for _, record := range records {
+			var err error
+			if s.deleteMode == config.ChunkDeleteModeTombstone {
+				record.Set("deleted", true)
+				err = txApp.Save(record)
+			} else {
+				err = txApp.Delete(record)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to remove chunk %s: %w", record.Id, err)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("failed to save to PocketBase: %w", err)
+		return err
 	}
+	s.flushOutbox(ctx)
 
-	// Index in MeiliSearch
-	doc := ChunkDocument{
+	s.logger.Info("Deleted messages removed from index",
+		zap.Int64("channelId", channelID),
+		zap.Ints("msgIds", msgIDs),
+		zap.String("mode", s.deleteMode),
+	)
+
+	return nil
+}
+
+// peerType returns the chunks.peerType value for a message peer.
+func peerType(p tg.PeerClass) string {
+	switch p.(type) {
+	case *tg.PeerChannel:
+		return "channel"
+	case *tg.PeerChat:
+		return "chat"
+	case *tg.PeerUser:
+		return "user"
+	default:
+		return ""
+	}
+}
+
+// newChunkDocument builds the index document for a chunks record.
+func newChunkDocument(record *core.Record, vectors chunkVectors) ChunkDocument {
+	return ChunkDocument{
 		ID:        record.Id,
-		Content:   text,
-		ChannelID: fmt.Sprintf("%d", channelID),
-		Link:      link,
+		Content:   record.GetString("content"),
+		ChannelID: record.GetString("channelId"),
+		MsgID:     record.GetInt("msgId"),
+		Position:  record.GetInt("position"),
+		Link:      record.GetString("link"),
 		Created:   record.GetDateTime("created").Time(),
 		Updated:   record.GetDateTime("updated").Time(),
+		GroupID:   groupOf(record.Id, record.GetString("duplicateGroup")),
 		Vectors: map[string][]float32{
-			"default": embedding,
+			vectorDefault: vectors.search,
+			vectorContent: vectors.content,
 		},
 	}
+}
 
-	if err := s.indexInMeiliSearch(ctx, doc); err != nil {
-		return fmt.Errorf("failed to index in MeiliSearch: %w", err)
+// RememberChannels caches the public usernames of the given channels, so citation links
+// point to t.me/<username>/<id> (readable by anyone) instead of members-only t.me/c/ links,
+// and their titles for the embedding template.
+func (s *Service) RememberChannels(channels map[int64]*tg.Channel) {
+	for id, channel := range channels {
+		if channel.Title != "" {
+			s.titles.Store(id, channel.Title)
+		}
+		if channel.Min {
+			// Min constructors may omit the username
+			continue
+		}
+		if username := channelUsername(channel); username != "" {
+			s.usernames.Store(id, username)
+		} else {
+			s.usernames.Delete(id)
+		}
 	}
+}
 
-	s.logger.Info("Message indexed successfully",
-		zap.String("id", record.Id),
-		zap.Int64("channelId", channelID),
-		zap.Int("msgId", msg.ID),
-	)
+// channelUsername returns the channel's public username, or "" for private channels.
+func channelUsername(channel *tg.Channel) string {
+	if channel.Username != "" {
+		return channel.Username
+	}
+	for _, u := range channel.Usernames {
+		if u.Active {
+			return u.Username
+		}
+	}
+	return ""
+}
 
-	return nil
+// messageLink builds the t.me link of a message, preferring the public form when the channel username is known.
+func (s *Service) messageLink(channelID int64, msgID int) string {
+	if username, ok := s.usernames.Load(channelID); ok {
+		return fmt.Sprintf("https://t.me/%s/%d", username, msgID)
+	}
+	return fmt.Sprintf("https://t.me/c/%d/%d", channelID, msgID)
+}
+
+// Relink rewrites the link of every stored chunk using the cached channel usernames,
+// updating PocketBase and, through the outbox, the indexed documents. Returns the
+// number of chunks changed.
+func (s *Service) Relink(ctx context.Context) (int, error) {
+	const pageSize = 500
+
+	changed := 0
+
+	for offset := 0; ; offset += pageSize {
+		records, err := s.app.FindRecordsByFilter("chunks", "deleted = false && (verdict != 'rejected' || override = true)", "created", pageSize, offset)
+		if err != nil {
+			return changed, fmt.Errorf("failed to load chunks: %w", err)
+		}
+		if len(records) == 0 {
+			break
+		}
+
+		for _, record := range records {
+			channelID, err := strconv.ParseInt(record.GetString("channelId"), 10, 64)
+			if err != nil || record.GetInt("msgId") == 0 {
+				continue
+			}
+
+			link := s.messageLink(channelID, record.GetInt("msgId"))
+			if link == record.GetString("link") {
+				continue
+			}
+
+			// The content is unchanged, so the dispatcher keeps the indexed vector
+			record.Set("link", link)
+			if err := s.app.Save(record); err != nil {
+				return changed, fmt.Errorf("failed to save chunk %s: %w", record.Id, err)
+			}
+			changed++
+		}
+
+		s.flushOutbox(ctx)
+	}
+
+	s.logger.Info("Chunk links rewritten", zap.Int("changed", changed))
+	return changed, nil
 }
 
-// generateEmbedding creates a vector embedding for the given text using OpenAI.
+// generateEmbedding creates a vector embedding for the given text with the configured embedder.
 func (s *Service) generateEmbedding(ctx context.Context, text string) ([]float32, error) {
-	resp, err := s.openai.CreateEmbeddings(ctx, openai.EmbeddingRequest{
-		Model: openai.EmbeddingModel(EmbeddingModel),
-		Input: []string{text},
-	})
+	vectors, err := s.embedder.Embed(ctx, []string{text})
 	if err != nil {
 		return nil, err
 	}
-
-	if len(resp.Data) == 0 {
-		return nil, fmt.Errorf("no embedding returned")
-	}
-
-	return resp.Data[0].Embedding, nil
+	return vectors[0], nil
 }
 
-// saveToPocketBase saves the message to the chunks collection.
-func (s *Service) saveToPocketBase(_ context.Context, text string, channelID int64, link string, rawMsg *tg.Message) (*core.Record, error) {
+// newChunkRecord builds an unsaved head chunk for the post, without content.
+func (s *Service) newChunkRecord(post Post, link string) (*core.Record, error) {
 	collection, err := s.app.FindCollectionByNameOrId("chunks")
 	if err != nil {
 		return nil, fmt.Errorf("chunks collection not found: %w", err)
 	}
 
 	record := core.NewRecord(collection)
-	record.Set("content", text)
-	record.Set("channelId", fmt.Sprintf("%d", channelID))
+	record.Set("channelId", fmt.Sprintf("%d", post.ChannelID))
 	record.Set("link", link)
-	record.Set("raw", rawMsg)
-
-	if err := s.app.Save(record); err != nil {
-		return nil, err
-	}
+	record.Set("msgId", post.Message.ID)
+	record.Set("peerType", peerType(post.Message.PeerID))
+	record.Set("raw", post.Message)
+	record.Set("meta", post.Meta)
 
 	return record, nil
 }
 
-// indexInMeiliSearch adds or updates a document in the search index.
-func (s *Service) indexInMeiliSearch(ctx context.Context, doc ChunkDocument) error {
-	index := s.meili.Index(s.indexUID)
-	primaryKey := "id"
-	task, err := index.AddDocuments([]ChunkDocument{doc}, &meilisearch.DocumentOptions{
-		PrimaryKey: &primaryKey,
+// FindChunk returns the head chunk indexed for the given channel message, or nil if there is none.
+func (s *Service) FindChunk(channelID int64, msgID int) (*core.Record, error) {
+	record, err := s.app.FindFirstRecordByFilter("chunks", "channelId = {:channelId} && msgId = {:msgId} && position = 0", dbx.Params{
+		"channelId": fmt.Sprintf("%d", channelID),
+		"msgId":     msgID,
 	})
-	if err != nil {
-		return err
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
 	}
+	return record, err
+}
 
-	return s.waitForTask(ctx, task.TaskUID)
+// isDuplicateChunk tells whether a save failed on the unique (channelId, msgId, position)
+// index, i.e. the message part is already stored.
+func isDuplicateChunk(err error) bool {
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return false
+	}
+	verr, ok := errs["msgId"].(validation.Error)
+	return ok && verr.Code() == "validation_not_unique"
 }
 
-// SearchHybrid performs a hybrid search (keyword + vector) in MeiliSearch.
+// SearchHybrid performs a hybrid search (keyword + vector) with the configured retriever.
+// Duplicate groups are collapsed into their best hit, which lists the links of the whole group.
 func (s *Service) SearchHybrid(ctx context.Context, query string, queryEmbedding []float32, limit int64) ([]ChunkDocument, error) {
-	index := s.meili.Index(s.indexUID)
-
-	searchRes, err := index.Search(query, &meilisearch.SearchRequest{
-		Limit: limit,
-		Hybrid: &meilisearch.SearchRequestHybrid{
-			SemanticRatio: 0.6, // 60% vector, 40% keyword
-			Embedder:      "default",
-		},
-		Vector:           queryEmbedding,
-		ShowRankingScore: true,
-		RankingScoreThreshold: 0.5,
-	})
+	docs, err := s.retriever.Search(ctx, query, queryEmbedding, int(limit))
 	if err != nil {
-		return nil, fmt.Errorf("search failed: %w", err)
+		return nil, err
 	}
 
-	// Convert hits to ChunkDocument using DecodeInto
-	docs := make([]ChunkDocument, 0, len(searchRes.Hits))
-	for _, hit := range searchRes.Hits {
-		var doc ChunkDocument
-		if err := hit.DecodeInto(&doc); err != nil {
-			s.logger.Warn("Failed to decode hit", zap.Error(err))
-			continue
-		}
-		docs = append(docs, doc)
-	}
+	s.attachGroupLinks(docs)
 
 	return docs, nil
 }
 
+// EmbeddingCache returns the embedding cache, nil if disabled.
+func (s *Service) EmbeddingCache() *embedding.Cache {
+	return s.cache
+}
+
+// EmbeddingModel identifies the model and dimensions of the vectors GenerateEmbedding
+// returns, so stored vectors can be recomputed when either changes.
+func (s *Service) EmbeddingModel() string {
+	return fmt.Sprintf("%s/%d", s.embedder.Model(), s.embedder.Dimensions())
+}
+
 // GenerateEmbedding is a public wrapper for generating embeddings (used by RAG service).
 func (s *Service) GenerateEmbedding(ctx context.Context, text string) ([]float32, error) {
 	return s.generateEmbedding(ctx, text)
 }
-
-// waitForTask blocks until the task is finished and returns an error if it failed.
-func (s *Service) waitForTask(_ context.Context, taskUID int64) error {
-	task, err := s.meili.WaitForTask(taskUID, time.Second*10)
-	if err != nil {
-		return err
-	}
-	if task.Status == meilisearch.TaskStatusFailed {
-		return fmt.Errorf("meilisearch task failed: %s (code: %s, type: %s)", task.Error.Message, task.Error.Code, task.Error.Type)
-	}
-	return nil
-}
@@ -0,0 +1,238 @@
+package indexer
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// charsPerToken is a conservative average for BPE tokenizers on mixed Latin and Cyrillic text.
+	charsPerToken = 3
+
+	paragraphSep = "\n\n"
+)
+
+var paragraphBreak = regexp.MustCompile(`\n\s*\n`)
+
+// segment is a piece of text that is never split further, with the separator that
+// precedes it when joined to the previous segment.
+type segment struct {
+	text   string
+	sep    string
+	tokens int
+}
+
+// textPart is a part of a split text.
+type textPart struct {
+	text string
+	join partJoin
+}
+
+// partJoin records how a part joins the previous part of its text, so the text can be
+// reassembled exactly. Parts after the first store it in their meta.
+type partJoin struct {
+	Overlap   int    `json:"overlap"`   // Leading runes repeated from the end of the previous part
+	Separator string `json:"separator"` // Inserted between the previous part and the rest
+}
+
+// estimateTokens approximates the number of tokens of a text without a tokenizer.
+func estimateTokens(text string) int {
+	tokens := 0
+	for _, word := range strings.Fields(text) {
+		tokens += (utf8.RuneCountInString(word) + charsPerToken - 1) / charsPerToken
+	}
+	return tokens
+}
+
+// splitText splits a text into parts of at most maxTokens on paragraph, then sentence,
+// then word boundaries. Each part after the first starts with up to overlap tokens of
+// trailing sentences of the previous part. Texts that fit are returned as a single part.
+func splitText(text string, maxTokens, overlap int) []textPart {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	if maxTokens <= 0 || estimateTokens(text) <= maxTokens {
+		return []textPart{{text: text}}
+	}
+
+	segs := segments(text, maxTokens)
+
+	var parts []textPart
+	var cur []segment
+	curTokens, carried := 0, 0
+	emit := func() {
+		part := textPart{text: joinSegments(cur)}
+		if len(parts) > 0 {
+			if carried > 0 {
+				part.join.Overlap = utf8.RuneCountInString(joinSegments(cur[:carried]))
+			} else {
+				part.join.Separator = cur[0].sep
+			}
+		}
+		parts = append(parts, part)
+	}
+	flush := func(next int) {
+		emit()
+
+		// Carry trailing segments over, but never the whole part
+		carry, carryTokens := 0, 0
+		for i := len(cur) - 1; i > 0 && carryTokens+cur[i].tokens <= overlap && carryTokens+cur[i].tokens+next <= maxTokens; i-- {
+			carry++
+			carryTokens += cur[i].tokens
+		}
+		cur = append([]segment(nil), cur[len(cur)-carry:]...)
+		curTokens, carried = carryTokens, carry
+	}
+
+	for i, seg := range segs {
+		// Start paragraphs that do not fit in a new part, unless that leaves this one mostly empty
+		if seg.sep == paragraphSep && len(cur) > 0 && curTokens >= maxTokens/2 {
+			if para := paragraphTokens(segs[i:]); curTokens+para > maxTokens {
+				flush(seg.tokens)
+			}
+		}
+		if curTokens+seg.tokens > maxTokens && len(cur) > 0 {
+			flush(seg.tokens)
+		}
+		cur = append(cur, seg)
+		curTokens += seg.tokens
+	}
+	if len(cur) > 0 {
+		emit()
+	}
+
+	return parts
+}
+
+// paragraphTokens counts the tokens of the paragraph starting at segs[0].
+func paragraphTokens(segs []segment) int {
+	tokens := 0
+	for i, seg := range segs {
+		if i > 0 && seg.sep == paragraphSep {
+			break
+		}
+		tokens += seg.tokens
+	}
+	return tokens
+}
+
+// segments breaks a text into sentences, and sentences that do not fit into words.
+// Segments starting a paragraph are separated by paragraphSep.
+func segments(text string, maxTokens int) []segment {
+	var out []segment
+	for _, para := range paragraphBreak.Split(text, -1) {
+		para = strings.TrimSpace(para)
+		if para == "" {
+			continue
+		}
+
+		for i, sentence := range splitSentences(para) {
+			if i == 0 {
+				sentence.sep = paragraphSep
+			}
+			if sentence.tokens <= maxTokens {
+				out = append(out, sentence)
+				continue
+			}
+			for j, word := range strings.Fields(sentence.text) {
+				sep := " "
+				if j == 0 {
+					sep = sentence.sep
+				}
+				out = append(out, splitWord(word, sep, maxTokens)...)
+			}
+		}
+	}
+	return out
+}
+
+// splitSentences splits a paragraph after sentence-ending punctuation and at line breaks.
+func splitSentences(para string) []segment {
+	var out []segment
+	start, sep := 0, ""
+	runes := []rune(para)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r != '\n' && !(strings.ContainsRune(".!?…", r) && i+1 < len(runes) && unicode.IsSpace(runes[i+1])) {
+			continue
+		}
+
+		end := i + 1
+		if r == '\n' {
+			end = i
+		}
+		j := end
+		for j < len(runes) && unicode.IsSpace(runes[j]) {
+			j++
+		}
+		if s := strings.TrimSpace(string(runes[start:end])); s != "" {
+			out = append(out, segment{text: s, sep: sep, tokens: estimateTokens(s)})
+			sep = " "
+			if strings.ContainsRune(string(runes[end:j]), '\n') {
+				sep = "\n"
+			}
+		}
+		start, i = j, j-1
+	}
+	if s := strings.TrimSpace(string(runes[start:])); s != "" {
+		out = append(out, segment{text: s, sep: sep, tokens: estimateTokens(s)})
+	}
+	return out
+}
+
+// splitWord cuts a word longer than maxTokens (e.g. an encoded blob) into pieces.
+func splitWord(word, sep string, maxTokens int) []segment {
+	if estimateTokens(word) <= maxTokens {
+		return []segment{{text: word, sep: sep, tokens: estimateTokens(word)}}
+	}
+
+	var out []segment
+	runes := []rune(word)
+	size := maxTokens * charsPerToken
+	for i := 0; i < len(runes); i += size {
+		piece := string(runes[i:min(i+size, len(runes))])
+		out = append(out, segment{text: piece, sep: sep, tokens: estimateTokens(piece)})
+		sep = ""
+	}
+	return out
+}
+
+func joinSegments(segs []segment) string {
+	var b strings.Builder
+	for i, seg := range segs {
+		if i > 0 {
+			b.WriteString(seg.sep)
+		}
+		b.WriteString(seg.text)
+	}
+	return b.String()
+}
+
+// joinParts joins consecutive parts of a message, dropping the overlap the second part
+// repeats from the end of the first one. Parts split before their join was recorded have
+// a nil join, which is guessed.
+func joinParts(a, b string, join *partJoin) string {
+	if join == nil {
+		return guessJoin(a, b)
+	}
+	runes := []rune(b)
+	return a + join.Separator + string(runes[min(join.Overlap, len(runes)):])
+}
+
+// guessJoin joins two parts on the longest suffix of a that starts b on word boundaries,
+// or on a paragraph break.
+func guessJoin(a, b string) string {
+	for k := min(len(a), len(b)); k > 0; k-- {
+		if (k == len(b) || isSpace(b[k])) && (k == len(a) || isSpace(a[len(a)-k-1])) && strings.HasSuffix(a, b[:k]) {
+			return a + b[k:]
+		}
+	}
+	return a + "\n\n" + b
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\n' || c == '\t'
+}
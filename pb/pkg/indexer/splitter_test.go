@@ -0,0 +1,128 @@
+package indexer
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSplitText(t *testing.T) {
+	sentence := "Мы ищем Go разработчика в команду платежей. "
+	long := strings.Repeat(sentence, 40)
+	paragraphs := strings.Repeat(strings.Repeat(sentence, 5)+"\n\n", 8)
+
+	tests := []struct {
+		name      string
+		text      string
+		maxTokens int
+		overlap   int
+		wantParts int // 0 to only check the limits
+	}{
+		{name: "empty", text: "  \n ", maxTokens: 10, wantParts: 0},
+		{name: "fits", text: "Short post.", maxTokens: 10, wantParts: 1},
+		{name: "no limit", text: long, maxTokens: 0, wantParts: 1},
+		{name: "sentences", text: long, maxTokens: 100},
+		{name: "sentences with overlap", text: long, maxTokens: 100, overlap: 30},
+		{name: "paragraphs", text: paragraphs, maxTokens: 120, overlap: 20},
+		{name: "long word", text: "blob " + strings.Repeat("x", 500), maxTokens: 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitText(tt.text, tt.maxTokens, tt.overlap)
+
+			if tt.wantParts > 0 || strings.TrimSpace(tt.text) == "" {
+				if len(parts) != tt.wantParts {
+					t.Fatalf("got %d parts, want %d", len(parts), tt.wantParts)
+				}
+			} else if len(parts) < 2 {
+				t.Fatalf("got %d parts, want several", len(parts))
+			}
+
+			for i, part := range parts {
+				if part.text == "" {
+					t.Errorf("part %d is empty", i)
+				}
+				if tt.maxTokens > 0 && estimateTokens(part.text) > tt.maxTokens {
+					t.Errorf("part %d has %d tokens, max %d", i, estimateTokens(part.text), tt.maxTokens)
+				}
+			}
+		})
+	}
+}
+
+// numberedText builds a text of distinct sentences (guessJoin cannot tell repeated
+// sentences from the overlap) with a paragraph break every perParagraph sentences.
+func numberedText(sentences, perParagraph int) string {
+	var b strings.Builder
+	for i := 0; i < sentences; i++ {
+		if i > 0 && i%perParagraph == 0 {
+			b.WriteString("\n\n")
+		} else if i > 0 {
+			b.WriteString(" ")
+		}
+		fmt.Fprintf(&b, "Sentence number %d about the payments team.", i)
+	}
+	return b.String()
+}
+
+func TestSplitTextJoinParts(t *testing.T) {
+	sentence := "Мы ищем Go разработчика в команду платежей. "
+	line := strings.TrimSpace(strings.Repeat(sentence, 7))
+	repeated := strings.TrimSpace(strings.Repeat(line+"\n", 6))
+
+	tests := []struct {
+		name    string
+		text    string
+		overlap int
+	}{
+		{name: "paragraphs", text: numberedText(40, 6), overlap: 0},
+		{name: "paragraphs with overlap", text: numberedText(40, 6), overlap: 20},
+		{name: "sentences", text: numberedText(40, 10), overlap: 0},
+		{name: "sentences with overlap", text: numberedText(40, 10), overlap: 20},
+		{name: "sentences with long overlap", text: numberedText(40, 10), overlap: 40},
+		// Repeated sentences, which guessJoin would take for the overlap
+		{name: "repeated sentences", text: repeated, overlap: 0},
+		{name: "repeated sentences with overlap", text: repeated, overlap: 20},
+		{name: "long word", text: "blob " + strings.Repeat("x", 500), overlap: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := splitText(tt.text, 100, tt.overlap)
+			if len(parts) < 2 {
+				t.Fatalf("got %d parts, want several", len(parts))
+			}
+
+			joined := parts[0].text
+			for _, part := range parts[1:] {
+				joined = joinParts(joined, part.text, &part.join)
+			}
+			if joined != tt.text {
+				t.Fatalf("joined parts differ from the text:\n%q\n%q", joined, tt.text)
+			}
+		})
+	}
+}
+
+func TestGuessJoin(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "overlap", a: "One. Two. Three.", b: "Three. Four.", want: "One. Two. Three. Four."},
+		{name: "longest overlap", a: "A b. A b.", b: "A b. A b. C.", want: "A b. A b. C."},
+		{name: "no overlap", a: "One.", b: "Two.", want: "One.\n\nTwo."},
+		{name: "partial word", a: "Go developer", b: "per day", want: "Go developer\n\nper day"},
+		{name: "whole part repeated", a: "Intro. Body.", b: "Body.", want: "Intro. Body."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := joinParts(tt.a, tt.b, nil); got != tt.want {
+				t.Fatalf("joinParts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
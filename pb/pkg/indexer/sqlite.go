@@ -0,0 +1,482 @@
+package indexer
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"svpb-tmpl/pkg/embedding"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+const (
+	rrfK           = 60 // Reciprocal rank fusion constant: higher flattens the rank differences
+	rrfCandidates  = 4  // Candidates taken from each ranking per result
+	sqliteScanPage = 1000
+
+	// minSimilarity is the cosine similarity a document must reach to be a search result
+	// without matching a keyword, standing for the ranking score threshold of MeiliSearch.
+	// Embeddings of unrelated texts commonly score 0.1-0.25 with general-purpose models.
+	minSimilarity = 0.3
+)
+
+// sqliteRetriever stores the chunk documents in the PocketBase database: a table of
+// documents and their vectors, and an FTS5 table over their content. Searches fuse the
+// BM25 keyword ranking with a brute-force cosine ranking of every vector, which is fast
+// enough for some hundred thousand chunks.
+type sqliteRetriever struct {
+	app    core.App
+	table  string // the FTS5 table is table + "_fts", whose rowids are the seq of the documents
+	logger *zap.Logger
+}
+
+func newSQLiteRetriever(app core.App, table string, logger *zap.Logger) *sqliteRetriever {
+	return &sqliteRetriever{
+		app:    app,
+		table:  table,
+		logger: logger,
+	}
+}
+
+// sqliteDocument is a row of the documents table.
+type sqliteDocument struct {
+	ID            string `db:"id"`
+	Doc           string `db:"doc"` // ChunkDocument without its vectors
+	Vector        []byte `db:"vector"`
+	ContentVector []byte `db:"contentVector"`
+	Updated       string `db:"updated"`
+}
+
+// EnsureIndex creates the tables if they don't exist.
+func (r *sqliteRetriever) EnsureIndex(ctx context.Context) error {
+	return r.app.RunInTransaction(func(txApp core.App) error {
+		// seq is an alias of the rowid, so unlike the implicit rowid it is kept by VACUUM
+		_, err := txApp.DB().NewQuery(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS {{%s}} (
+			[[seq]]     INTEGER PRIMARY KEY,
+			[[id]]      TEXT UNIQUE NOT NULL,
+			[[groupId]] TEXT DEFAULT '' NOT NULL,
+			[[updated]] TEXT DEFAULT '' NOT NULL,
+			[[doc]]     JSON DEFAULT '{}' NOT NULL,
+			[[vector]]  BLOB,
+			[[contentVector]] BLOB
+		)`, r.table)).Execute()
+		if err != nil {
+			return fmt.Errorf("failed to create table %s: %w", r.table, err)
+		}
+
+		// Tables created before content vectors were stored
+		var exists bool
+		err = txApp.DB().NewQuery("SELECT COUNT(*) > 0 FROM pragma_table_info({:table}) WHERE name = 'contentVector'").
+			Bind(dbx.Params{"table": r.table}).
+			Row(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to read the columns of %s: %w", r.table, err)
+		}
+		if !exists {
+			_, err = txApp.DB().NewQuery(fmt.Sprintf("ALTER TABLE {{%s}} ADD COLUMN [[contentVector]] BLOB", r.table)).Execute()
+			if err != nil {
+				return fmt.Errorf("failed to add column contentVector to %s: %w", r.table, err)
+			}
+		}
+
+		_, err = txApp.DB().NewQuery(fmt.Sprintf(
+			"CREATE VIRTUAL TABLE IF NOT EXISTS {{%s_fts}} USING fts5(content, tokenize='unicode61 remove_diacritics 2')",
+			r.table,
+		)).Execute()
+		if err != nil {
+			return fmt.Errorf("failed to create table %s_fts: %w", r.table, err)
+		}
+		return nil
+	})
+}
+
+// Upsert replaces the rows of the documents, in one transaction.
+func (r *sqliteRetriever) Upsert(ctx context.Context, docs []ChunkDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	return r.app.RunInTransaction(func(txApp core.App) error {
+		ids := make([]string, len(docs))
+		for i, doc := range docs {
+			ids[i] = doc.ID
+		}
+		if err := r.delete(txApp, ids); err != nil {
+			return err
+		}
+
+		for _, doc := range docs {
+			vectors := doc.vectors()
+			doc.Vectors = nil
+			doc.RankingScore = 0
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return fmt.Errorf("failed to encode document %s: %w", doc.ID, err)
+			}
+
+			res, err := txApp.DB().Insert(r.table, dbx.Params{
+				"id":            doc.ID,
+				"groupId":       doc.GroupID,
+				"updated":       doc.Updated.UTC().Format(time.RFC3339Nano),
+				"doc":           string(data),
+				"vector":        encodeVector(vectors.search),
+				"contentVector": encodeVector(vectors.content),
+			}).Execute()
+			if err != nil {
+				return fmt.Errorf("failed to index document %s: %w", doc.ID, err)
+			}
+			seq, err := res.LastInsertId()
+			if err != nil {
+				return fmt.Errorf("failed to index document %s: %w", doc.ID, err)
+			}
+
+			_, err = txApp.DB().Insert(r.table+"_fts", dbx.Params{
+				"rowid":   seq,
+				"content": doc.Content,
+			}).Execute()
+			if err != nil {
+				return fmt.Errorf("failed to index content of %s: %w", doc.ID, err)
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes the rows of the documents.
+func (r *sqliteRetriever) Delete(ctx context.Context, ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return r.app.RunInTransaction(func(txApp core.App) error {
+		return r.delete(txApp, ids)
+	})
+}
+
+// delete removes documents and their content, within a transaction.
+func (r *sqliteRetriever) delete(txApp core.App, ids []string) error {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	var seqs []interface{}
+	if err := txApp.DB().Select("seq").From(r.table).Where(dbx.In("id", values...)).Column(&seqs); err != nil {
+		return fmt.Errorf("failed to find documents: %w", err)
+	}
+	if len(seqs) == 0 {
+		return nil
+	}
+
+	if _, err := txApp.DB().Delete(r.table+"_fts", dbx.In("rowid", seqs...)).Execute(); err != nil {
+		return fmt.Errorf("failed to delete document content: %w", err)
+	}
+	if _, err := txApp.DB().Delete(r.table, dbx.In("id", values...)).Execute(); err != nil {
+		return fmt.Errorf("failed to delete documents: %w", err)
+	}
+	return nil
+}
+
+// Search fuses the keyword and vector rankings with weighted reciprocal rank fusion and
+// keeps the best document of each duplicate group. The ranking score is the fused score
+// scaled to 1 for a document first in both rankings. Ranks don't tell how relevant the
+// last results are, so only documents matching a keyword or similar enough to the query
+// (minSimilarity) are results.
+func (r *sqliteRetriever) Search(ctx context.Context, query string, vector []float32, limit int) ([]ChunkDocument, error) {
+	candidates := limit * rrfCandidates
+
+	keyword, err := r.keywordRanking(query, candidates)
+	if err != nil {
+		return nil, err
+	}
+	semantic, err := r.vectorRanking(ctx, "vector", vector, candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	scores := make(map[string]float64, len(keyword)+len(semantic))
+	for rank, id := range keyword {
+		scores[id] += (1 - semanticRatio) / float64(rrfK+rank+1)
+	}
+	for rank, hit := range semantic {
+		if hit.score < minSimilarity {
+			// Best first, so the rest are not similar either
+			break
+		}
+		scores[hit.id] += semanticRatio / float64(rrfK+rank+1)
+	}
+
+	ids := make([]string, 0, len(scores))
+	for id := range scores {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if scores[ids[i]] != scores[ids[j]] {
+			return scores[ids[i]] > scores[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	docs, err := r.load(ids, false)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[string]ChunkDocument, len(docs))
+	for _, doc := range docs {
+		byID[doc.ID] = doc
+	}
+
+	results := make([]ChunkDocument, 0, limit)
+	groups := make(map[string]bool)
+	for _, id := range ids {
+		doc, ok := byID[id]
+		if !ok || groups[doc.GroupID] {
+			continue
+		}
+		groups[doc.GroupID] = true
+
+		doc.RankingScore = scores[id] * (rrfK + 1)
+		results = append(results, doc)
+		if len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// Nearest returns the documents with the most similar content vectors.
+func (r *sqliteRetriever) Nearest(ctx context.Context, vector []float32, limit int) ([]ChunkDocument, error) {
+	hits, err := r.vectorRanking(ctx, "contentVector", vector, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.id
+	}
+	docs, err := r.load(ids, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// In order of similarity
+	rank := make(map[string]int, len(ids))
+	for i, id := range ids {
+		rank[id] = i
+	}
+	sort.Slice(docs, func(i, j int) bool { return rank[docs[i].ID] < rank[docs[j].ID] })
+	return docs, nil
+}
+
+// Documents returns the given documents with their vectors.
+func (r *sqliteRetriever) Documents(ctx context.Context, ids []string) ([]ChunkDocument, error) {
+	return r.load(ids, true)
+}
+
+// Updated lists the updated timestamps of the documents.
+func (r *sqliteRetriever) Updated(ctx context.Context) (map[string]time.Time, error) {
+	var rows []sqliteDocument
+	if err := r.app.DB().Select("id", "updated").From(r.table).All(&rows); err != nil {
+		return nil, fmt.Errorf("failed to list documents: %w", err)
+	}
+
+	documents := make(map[string]time.Time, len(rows))
+	for _, row := range rows {
+		updated, _ := time.Parse(time.RFC3339Nano, row.Updated)
+		documents[row.ID] = updated
+	}
+	return documents, nil
+}
+
+// Rebuild fills tables named name and replaces the live tables with them in one transaction.
+func (r *sqliteRetriever) Rebuild(ctx context.Context, name string, fill func(staging Retriever) error) error {
+	staging := newSQLiteRetriever(r.app, name, r.logger)
+
+	fail := func(err error) error {
+		if dropErr := staging.drop(r.app); dropErr != nil {
+			r.logger.Warn("Failed to drop the unfinished index", zap.String("index", name), zap.Error(dropErr))
+		}
+		return err
+	}
+
+	if err := r.EnsureIndex(ctx); err != nil {
+		return fail(err)
+	}
+	if err := staging.EnsureIndex(ctx); err != nil {
+		return fail(err)
+	}
+
+	if err := fill(staging); err != nil {
+		return fail(err)
+	}
+
+	err := r.app.RunInTransaction(func(txApp core.App) error {
+		if err := r.drop(txApp); err != nil {
+			return err
+		}
+		for _, suffix := range []string{"", "_fts"} {
+			_, err := txApp.DB().NewQuery(fmt.Sprintf("ALTER TABLE {{%s%s}} RENAME TO {{%s%s}}", name, suffix, r.table, suffix)).Execute()
+			if err != nil {
+				return fmt.Errorf("failed to rename table %s%s: %w", name, suffix, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fail(fmt.Errorf("failed to swap indexes: %w", err))
+	}
+	return nil
+}
+
+// drop deletes the tables.
+func (r *sqliteRetriever) drop(app core.App) error {
+	for _, table := range []string{r.table + "_fts", r.table} {
+		if _, err := app.DB().NewQuery(fmt.Sprintf("DROP TABLE IF EXISTS {{%s}}", table)).Execute(); err != nil {
+			return fmt.Errorf("failed to drop table %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+// keywordRanking returns the IDs of the documents matching any word of the query, best
+// BM25 score first.
+func (r *sqliteRetriever) keywordRanking(query string, limit int) ([]string, error) {
+	words := strings.FieldsFunc(query, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+	if len(words) == 0 {
+		return nil, nil
+	}
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+
+	var ids []string
+	err := r.app.DB().NewQuery(fmt.Sprintf(
+		"SELECT d.[[id]] FROM {{%[1]s_fts}} f JOIN {{%[1]s}} d ON d.[[seq]] = f.[[rowid]] WHERE {{%[1]s_fts}} MATCH {:match} ORDER BY bm25({{%[1]s_fts}}) LIMIT {:limit}",
+		r.table,
+	)).Bind(dbx.Params{"match": strings.Join(words, " OR "), "limit": limit}).Column(&ids)
+	if err != nil {
+		return nil, fmt.Errorf("keyword search failed: %w", err)
+	}
+	return ids, nil
+}
+
+type vectorHit struct {
+	id    string
+	score float64
+}
+
+// vectorRanking scans every vector stored in column, vector or contentVector, and returns
+// the limit most similar, best first.
+func (r *sqliteRetriever) vectorRanking(ctx context.Context, column string, vector []float32, limit int) ([]vectorHit, error) {
+	if len(vector) == 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	var best []vectorHit
+	after := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		var rows []sqliteDocument
+		err := r.app.DB().Select("id", column+" AS vector").
+			From(r.table).
+			Where(dbx.NewExp("[[id]] > {:after}", dbx.Params{"after": after})).
+			OrderBy("id").
+			Limit(sqliteScanPage).
+			All(&rows)
+		if err != nil {
+			return nil, fmt.Errorf("vector search failed: %w", err)
+		}
+
+		for _, row := range rows {
+			score := embedding.Cosine(vector, decodeVector(row.Vector))
+			if len(best) == limit && score <= best[limit-1].score {
+				continue
+			}
+
+			// Insert in order, dropping the worst hit when full
+			i := sort.Search(len(best), func(i int) bool { return best[i].score < score })
+			if len(best) < limit {
+				best = append(best, vectorHit{})
+			}
+			copy(best[i+1:], best[i:])
+			best[i] = vectorHit{id: row.ID, score: score}
+		}
+
+		if len(rows) < sqliteScanPage {
+			return best, nil
+		}
+		after = rows[len(rows)-1].ID
+	}
+}
+
+// load reads documents by ID, in no particular order.
+func (r *sqliteRetriever) load(ids []string, withVectors bool) ([]ChunkDocument, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+
+	columns := []string{"id", "doc"}
+	if withVectors {
+		columns = append(columns, "vector", "contentVector")
+	}
+
+	var rows []sqliteDocument
+	if err := r.app.DB().Select(columns...).From(r.table).Where(dbx.In("id", values...)).All(&rows); err != nil {
+		return nil, fmt.Errorf("failed to read documents: %w", err)
+	}
+
+	docs := make([]ChunkDocument, 0, len(rows))
+	for _, row := range rows {
+		var doc ChunkDocument
+		if err := json.Unmarshal([]byte(row.Doc), &doc); err != nil {
+			r.logger.Warn("Failed to decode document", zap.String("id", row.ID), zap.Error(err))
+			continue
+		}
+		if vector := decodeVector(row.Vector); len(vector) > 0 {
+			doc.Vectors = map[string][]float32{vectorDefault: vector}
+			if content := decodeVector(row.ContentVector); len(content) > 0 {
+				doc.Vectors[vectorContent] = content
+			}
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// encodeVector packs a vector as little-endian float32s.
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+// decodeVector unpacks a vector packed by encodeVector.
+func decodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}
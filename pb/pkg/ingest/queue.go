@@ -0,0 +1,413 @@
+package ingest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gotd/td/bin"
+	"github.com/gotd/td/tg"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"go.uber.org/zap"
+)
+
+const CollectionName = "ingest_jobs"
+
+// Job kinds.
+const (
+	KindIndex   = "index"   // index new messages (several for an album)
+	KindEdit    = "edit"    // re-index an edited message
+	KindDelete  = "delete"  // remove deleted messages
+	KindAnalyze = "analyze" // run LLM vacancy analysis on an indexed chunk
+	KindExtract = "extract" // run an extraction profile on an indexed chunk
+)
+
+// Job statuses. Completed jobs are deleted.
+const (
+	StatusPending    = "pending"
+	StatusProcessing = "processing"
+	StatusDead       = "dead"
+)
+
+const (
+	pollInterval = 5 * time.Second
+	baseBackoff  = 5 * time.Second
+	maxBackoff   = time.Hour
+)
+
+// Job is a unit of ingestion work.
+type Job struct {
+	ID        string
+	Kind      string
+	ChatID    int64
+	Messages  []*tg.Message
+	MsgIDs    []int
+	ChunkID   string
+	ProfileID string
+	Attempts  int
+}
+
+// Processor performs a job. A returned error schedules a retry.
+type Processor func(ctx context.Context, job *Job) error
+
+// Queue is a durable job queue backed by the ingest_jobs collection. Failed jobs are retried
+// with exponential backoff and marked dead after maxAttempts, until requeued. Jobs on the
+// same message run in the order they were enqueued (see jobKeys), so e.g. a retried index
+// job can't recreate a message deleted after it. Only the kinds with a registered processor
+// are claimed (see Handle).
+type Queue struct {
+	app    core.App
+	logger *zap.Logger
+
+	workers     int
+	maxAttempts int
+
+	mu         sync.Mutex // serializes job claiming between workers, guards processors
+	processors map[string]Processor
+	wake       chan struct{}
+}
+
+// NewQueue creates a new ingestion queue.
+func NewQueue(app core.App, workers, maxAttempts int, logger *zap.Logger) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+	if maxAttempts <= 0 {
+		maxAttempts = 1
+	}
+	return &Queue{
+		app:         app,
+		logger:      logger,
+		workers:     workers,
+		maxAttempts: maxAttempts,
+		processors:  make(map[string]Processor),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the processor of a job kind. Jobs of kinds without a processor stay
+// pending until one is registered, e.g. message jobs until the Telegram client is connected.
+func (q *Queue) Handle(kind string, process Processor) {
+	q.mu.Lock()
+	q.processors[kind] = process
+	q.mu.Unlock()
+
+	q.notify()
+}
+
+// notify wakes up an idle worker.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Enqueue persists a job for the workers to pick up.
+func (q *Queue) Enqueue(job *Job) error {
+	collection, err := q.app.FindCollectionByNameOrId(CollectionName)
+	if err != nil {
+		return fmt.Errorf("ingest_jobs collection not found: %w", err)
+	}
+
+	messages := make([]string, 0, len(job.Messages))
+	for _, msg := range job.Messages {
+		var b bin.Buffer
+		if err := msg.Encode(&b); err != nil {
+			return fmt.Errorf("failed to encode message %d: %w", msg.ID, err)
+		}
+		messages = append(messages, base64.StdEncoding.EncodeToString(b.Buf))
+	}
+
+	record := core.NewRecord(collection)
+	record.Set("kind", job.Kind)
+	record.Set("chatId", strconv.FormatInt(job.ChatID, 10))
+	record.Set("messages", messages)
+	record.Set("msgIds", job.MsgIDs)
+	record.Set("keys", jobKeys(job))
+	record.Set("chunkId", job.ChunkID)
+	record.Set("profileId", job.ProfileID)
+	record.Set("status", StatusPending)
+	record.Set("nextAttemptAt", types.NowDateTime())
+
+	if err := q.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save job: %w", err)
+	}
+	job.ID = record.Id
+
+	q.notify()
+
+	return nil
+}
+
+// Run drains the queue with a pool of workers until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context) {
+	// Jobs left processing by a previous run were interrupted
+	if _, err := q.app.DB().Update(CollectionName,
+		dbx.Params{"status": StatusPending},
+		dbx.HashExp{"status": StatusProcessing},
+	).Execute(); err != nil {
+		q.logger.Error("Failed to reset interrupted jobs", zap.Error(err))
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	for {
+		record, process, err := q.claim()
+		if err != nil {
+			q.logger.Error("Failed to claim job", zap.Error(err))
+		}
+		if record == nil {
+			select {
+			case <-q.wake:
+			case <-time.After(pollInterval):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		q.run(ctx, record, process)
+	}
+}
+
+// claim marks the next due pending job as processing and returns it with its processor, or nil
+// if there is none. Jobs sharing a key with an earlier job that is not done or dead wait for it.
+func (q *Queue) claim() (*core.Record, Processor, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.processors) == 0 {
+		return nil, nil, nil
+	}
+	kinds := make([]interface{}, 0, len(q.processors))
+	for kind := range q.processors {
+		kinds = append(kinds, kind)
+	}
+
+	var records []*core.Record
+	err := q.app.RecordQuery(CollectionName).
+		AndWhere(dbx.HashExp{"status": StatusPending, "kind": kinds}).
+		AndWhere(dbx.NewExp("[[nextAttemptAt]] <= {:now}", dbx.Params{"now": types.NowDateTime().String()})).
+		AndWhere(dbx.NewExp(`NOT EXISTS (
+			SELECT 1 FROM {{ingest_jobs}} AS earlier, json_each(earlier.[[keys]]) AS ek, json_each({{ingest_jobs}}.[[keys]]) AS jk
+			WHERE earlier.[[rowid]] < {{ingest_jobs}}.[[rowid]]
+				AND earlier.[[status]] IN ({:pending}, {:processing})
+				AND ek.[[value]] = jk.[[value]]
+		)`, dbx.Params{"pending": StatusPending, "processing": StatusProcessing})).
+		OrderBy("nextAttemptAt").
+		Limit(1).
+		All(&records)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil, nil
+	}
+	record := records[0]
+
+	record.Set("status", StatusProcessing)
+	if err := q.app.Save(record); err != nil {
+		return nil, nil, err
+	}
+
+	return record, q.processors[record.GetString("kind")], nil
+}
+
+// run processes a claimed job, deleting it on success and scheduling a retry on failure.
+func (q *Queue) run(ctx context.Context, record *core.Record, process Processor) {
+	job, err := decodeJob(record)
+	if err == nil {
+		err = process(ctx, job)
+	}
+
+	if err == nil {
+		if err := q.app.Delete(record); err != nil {
+			q.logger.Error("Failed to delete completed job", zap.String("id", record.Id), zap.Error(err))
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		// Shutting down: retry on the next run without counting the attempt
+		record.Set("status", StatusPending)
+		if err := q.app.Save(record); err != nil {
+			q.logger.Error("Failed to release job", zap.String("id", record.Id), zap.Error(err))
+		}
+		return
+	}
+
+	attempts := record.GetInt("attempts") + 1
+	record.Set("attempts", attempts)
+	record.Set("error", err.Error())
+
+	if attempts >= q.maxAttempts {
+		record.Set("status", StatusDead)
+		q.logger.Error("Job failed permanently",
+			zap.String("id", record.Id),
+			zap.String("kind", record.GetString("kind")),
+			zap.Int("attempts", attempts),
+			zap.Error(err),
+		)
+	} else {
+		delay := backoff(attempts)
+		record.Set("status", StatusPending)
+		record.Set("nextAttemptAt", types.NowDateTime().Add(delay))
+		q.logger.Warn("Job failed, retrying",
+			zap.String("id", record.Id),
+			zap.String("kind", record.GetString("kind")),
+			zap.Int("attempts", attempts),
+			zap.Duration("retryIn", delay),
+			zap.Error(err),
+		)
+	}
+
+	if err := q.app.Save(record); err != nil {
+		q.logger.Error("Failed to save failed job", zap.String("id", record.Id), zap.Error(err))
+	}
+}
+
+// Requeue moves dead jobs back to pending with a fresh retry budget. With no IDs,
+// all dead jobs are requeued. Returns the number of jobs requeued.
+func (q *Queue) Requeue(ids []string) (int, error) {
+	where := dbx.HashExp{"status": StatusDead}
+	if len(ids) > 0 {
+		values := make([]interface{}, len(ids))
+		for i, id := range ids {
+			values[i] = id
+		}
+		where["id"] = values
+	}
+
+	records, err := q.app.FindAllRecords(CollectionName, where)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find dead jobs: %w", err)
+	}
+
+	for _, record := range records {
+		record.Set("status", StatusPending)
+		record.Set("attempts", 0)
+		record.Set("error", "")
+		record.Set("nextAttemptAt", types.NowDateTime())
+		if err := q.app.Save(record); err != nil {
+			return 0, fmt.Errorf("failed to requeue job %s: %w", record.Id, err)
+		}
+	}
+
+	if len(records) > 0 {
+		q.notify()
+	}
+
+	return len(records), nil
+}
+
+// jobKeys returns the messages a job works on, as "<chatId>:<msgId>" plus "<chatId>:g<groupedId>"
+// for albums (whose chunk is shared by their messages). Messages of legacy groups and private
+// chats also get a "0:<msgId>" key, matching their deletions, which carry no peer.
+func jobKeys(job *Job) []string {
+	var keys []string
+	add := func(key string) {
+		for _, k := range keys {
+			if k == key {
+				return
+			}
+		}
+		keys = append(keys, key)
+	}
+
+	for _, msg := range job.Messages {
+		add(fmt.Sprintf("%d:%d", job.ChatID, msg.ID))
+		if msg.GroupedID != 0 {
+			add(fmt.Sprintf("%d:g%d", job.ChatID, msg.GroupedID))
+		}
+		if _, ok := msg.PeerID.(*tg.PeerChannel); !ok {
+			add(fmt.Sprintf("0:%d", msg.ID))
+		}
+	}
+	for _, id := range job.MsgIDs {
+		add(fmt.Sprintf("%d:%d", job.ChatID, id))
+	}
+
+	return keys
+}
+
+// backoff returns the delay before the given retry attempt: baseBackoff doubled per attempt, capped at maxBackoff.
+func backoff(attempts int) time.Duration {
+	delay := baseBackoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+func decodeJob(record *core.Record) (*Job, error) {
+	job := &Job{
+		ID:        record.Id,
+		Kind:      record.GetString("kind"),
+		ChunkID:   record.GetString("chunkId"),
+		ProfileID: record.GetString("profileId"),
+		Attempts:  record.GetInt("attempts"),
+	}
+
+	chatID, err := strconv.ParseInt(record.GetString("chatId"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid chat ID %q: %w", record.GetString("chatId"), err)
+	}
+	job.ChatID = chatID
+
+	var messages []string
+	if err := record.UnmarshalJSONField("messages", &messages); err != nil {
+		return nil, fmt.Errorf("failed to read messages: %w", err)
+	}
+	for _, encoded := range messages {
+		data, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode message: %w", err)
+		}
+		msg := &tg.Message{}
+		if err := msg.Decode(&bin.Buffer{Buf: data}); err != nil {
+			return nil, fmt.Errorf("failed to decode message: %w", err)
+		}
+		job.Messages = append(job.Messages, msg)
+	}
+
+	if err := record.UnmarshalJSONField("msgIds", &job.MsgIDs); err != nil {
+		return nil, fmt.Errorf("failed to read message IDs: %w", err)
+	}
+
+	return job, nil
+}
+
+// HandleRequeue is an admin route that requeues dead jobs: the ones listed in
+// the "ids" body field, or all of them if it is empty.
+func (q *Queue) HandleRequeue(e *core.RequestEvent) error {
+	var body struct {
+		IDs []string `json:"ids"`
+	}
+	if err := e.BindBody(&body); err != nil {
+		return e.BadRequestError("Invalid request body", err)
+	}
+
+	n, err := q.Requeue(body.IDs)
+	if err != nil {
+		return e.InternalServerError("Failed to requeue jobs", err)
+	}
+
+	q.logger.Info("Dead jobs requeued", zap.Int("count", n))
+	return e.JSON(200, map[string]interface{}{"requeued": n})
+}
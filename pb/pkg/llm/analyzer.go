@@ -3,6 +3,7 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"os"
 
 	openai "github.com/sashabaranov/go-openai"
@@ -76,6 +77,14 @@ Always respond with valid JSON matching the schema exactly.`
 
 // AnalyzeVacancy sends the message text to LLM and returns structured job data.
 func (a *Analyzer) AnalyzeVacancy(ctx context.Context, text string) (JobParsedData, error) {
+	var result JobParsedData
+	err := a.Extract(ctx, "job_parser", SystemPrompt, JobParsedData{}.Schema(), text, &result)
+	return result, err
+}
+
+// Extract sends the text to LLM with a strict JSON schema response format and decodes
+// the structured output into result. The schema can be a *jsonschema.Definition or raw JSON.
+func (a *Analyzer) Extract(ctx context.Context, name, systemPrompt string, schema json.Marshaler, text string, result interface{}) error {
 	resp, err := a.client.CreateChatCompletion(
 		ctx,
 		openai.ChatCompletionRequest{
@@ -83,7 +92,7 @@ func (a *Analyzer) AnalyzeVacancy(ctx context.Context, text string) (JobParsedDa
 			Messages: []openai.ChatCompletionMessage{
 				{
 					Role:    openai.ChatMessageRoleSystem,
-					Content: SystemPrompt,
+					Content: systemPrompt,
 				},
 				{
 					Role:    openai.ChatMessageRoleUser,
@@ -93,8 +102,8 @@ func (a *Analyzer) AnalyzeVacancy(ctx context.Context, text string) (JobParsedDa
 			ResponseFormat: &openai.ChatCompletionResponseFormat{
 				Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
 				JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
-					Name:   "job_parser",
-					Schema: JobParsedData{}.Schema(),
+					Name:   name,
+					Schema: schema,
 					Strict: true,
 				},
 			},
@@ -102,10 +111,44 @@ func (a *Analyzer) AnalyzeVacancy(ctx context.Context, text string) (JobParsedDa
 	)
 
 	if err != nil {
-		return JobParsedData{}, err
+		return err
+	}
+	if len(resp.Choices) == 0 {
+		return errors.New("empty completion")
 	}
 
-	var result JobParsedData
-	err = json.Unmarshal([]byte(resp.Choices[0].Message.Content), &result)
+	return json.Unmarshal([]byte(resp.Choices[0].Message.Content), result)
+}
+
+// SpamVerdict represents the structured output of spam classification.
+type SpamVerdict struct {
+	IsSpam bool   `json:"isSpam"` // True for advertisements, promotions and cross-promo posts
+	Reason string `json:"reason"` // Short explanation of the verdict
+}
+
+func (v SpamVerdict) Schema() *jsonschema.Definition {
+	schema, err := jsonschema.GenerateSchemaForType(v)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// SpamPrompt defines the LLM's behavior for classifying spam and advertisements.
+const SpamPrompt = `You are a content moderator for a search index built from Telegram channels. Your task is to decide whether a post is spam.
+
+A post IS spam if it is mainly:
+1. An advertisement or paid promotion of a product, service or course.
+2. A "subscribe to our partner" or channel cross-promotion post.
+3. A giveaway, referral link, casino, betting or crypto pump.
+
+A post is NOT spam if it carries its own content (news, articles, job vacancies, announcements, discussions), even when it contains a link.
+
+Set isSpam accordingly and give a short reason. Always respond with valid JSON matching the schema exactly.`
+
+// ClassifySpam sends the message text to LLM and returns whether it is spam.
+func (a *Analyzer) ClassifySpam(ctx context.Context, text string) (SpamVerdict, error) {
+	var result SpamVerdict
+	err := a.Extract(ctx, "spam_classifier", SpamPrompt, SpamVerdict{}.Schema(), text, &result)
 	return result, err
 }
@@ -0,0 +1,110 @@
+package markdown
+
+import (
+	"fmt"
+	"sort"
+	"unicode/utf16"
+
+	"github.com/gotd/td/tg"
+)
+
+// span is a formatting entity converted to markdown markers around a UTF-16 range.
+type span struct {
+	start, end  int
+	open, close string
+	trim        bool // exclude surrounding whitespace from the range (inline markers break on it)
+}
+
+// FromTelegram renders a Telegram message text with its formatting entities as markdown:
+// bold, italic, strikethrough, inline code, code blocks, hidden links and mentions of
+// users without a username. Other entities (plain URLs, @mentions, hashtags) are already
+// readable in the text and are left as is.
+func FromTelegram(text string, entities []tg.MessageEntityClass) string {
+	if len(entities) == 0 {
+		return text
+	}
+
+	// Entity offsets and lengths are in UTF-16 code units
+	units := utf16.Encode([]rune(text))
+
+	spans := make([]span, 0, len(entities))
+	for _, entity := range entities {
+		s, ok := toSpan(entity)
+		if !ok {
+			continue
+		}
+		s.start = entity.GetOffset()
+		s.end = s.start + entity.GetLength()
+		if s.start < 0 || s.end > len(units) {
+			continue
+		}
+		if s.trim {
+			for s.start < s.end && isSpace(units[s.start]) {
+				s.start++
+			}
+			for s.end > s.start && isSpace(units[s.end-1]) {
+				s.end--
+			}
+		}
+		if s.start == s.end {
+			continue
+		}
+		spans = append(spans, s)
+	}
+
+	// Outer entities open first and close last
+	sort.SliceStable(spans, func(i, j int) bool {
+		if spans[i].start != spans[j].start {
+			return spans[i].start < spans[j].start
+		}
+		return spans[i].end > spans[j].end
+	})
+
+	opens := make(map[int][]string)
+	closes := make(map[int][]string)
+	for _, s := range spans {
+		opens[s.start] = append(opens[s.start], s.open)
+		closes[s.end] = append([]string{s.close}, closes[s.end]...)
+	}
+
+	out := make([]uint16, 0, len(units)+len(spans)*4)
+	for i := 0; i <= len(units); i++ {
+		for _, marker := range closes[i] {
+			out = append(out, utf16.Encode([]rune(marker))...)
+		}
+		for _, marker := range opens[i] {
+			out = append(out, utf16.Encode([]rune(marker))...)
+		}
+		if i < len(units) {
+			out = append(out, units[i])
+		}
+	}
+
+	return string(utf16.Decode(out))
+}
+
+// toSpan returns the markdown markers of an entity, or false if it is kept as plain text.
+func toSpan(entity tg.MessageEntityClass) (span, bool) {
+	switch e := entity.(type) {
+	case *tg.MessageEntityBold:
+		return span{open: "**", close: "**", trim: true}, true
+	case *tg.MessageEntityItalic:
+		return span{open: "_", close: "_", trim: true}, true
+	case *tg.MessageEntityStrike:
+		return span{open: "~~", close: "~~", trim: true}, true
+	case *tg.MessageEntityCode:
+		return span{open: "`", close: "`", trim: true}, true
+	case *tg.MessageEntityPre:
+		return span{open: "```" + e.Language + "\n", close: "\n```"}, true
+	case *tg.MessageEntityTextURL:
+		return span{open: "[", close: fmt.Sprintf("](%s)", e.URL), trim: true}, true
+	case *tg.MessageEntityMentionName:
+		return span{open: "[", close: fmt.Sprintf("](tg://user?id=%d)", e.UserID), trim: true}, true
+	default:
+		return span{}, false
+	}
+}
+
+func isSpace(u uint16) bool {
+	return u == ' ' || u == '\n' || u == '\t' || u == '\r'
+}
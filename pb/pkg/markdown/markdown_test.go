@@ -0,0 +1,99 @@
+package markdown
+
+import (
+	"testing"
+
+	"github.com/gotd/td/tg"
+)
+
+func TestFromTelegram(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities []tg.MessageEntityClass
+		want     string
+	}{
+		{
+			name: "no entities",
+			text: "Plain *text*",
+			want: "Plain *text*",
+		},
+		{
+			name:     "bold",
+			text:     "Hiring now",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 0, Length: 6}},
+			want:     "**Hiring** now",
+		},
+		{
+			name:     "trailing space outside markers",
+			text:     "Hiring now",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityItalic{Offset: 0, Length: 7}},
+			want:     "_Hiring_ now",
+		},
+		{
+			name: "nested",
+			text: "Go developer",
+			entities: []tg.MessageEntityClass{
+				&tg.MessageEntityItalic{Offset: 0, Length: 2},
+				&tg.MessageEntityBold{Offset: 0, Length: 12},
+			},
+			want: "**_Go_ developer**",
+		},
+		{
+			name:     "strike and code",
+			text:     "old go1.20 new",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityStrike{Offset: 0, Length: 3}, &tg.MessageEntityCode{Offset: 4, Length: 6}},
+			want:     "~~old~~ `go1.20` new",
+		},
+		{
+			name:     "code block",
+			text:     "Run:\nmake test",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityPre{Offset: 5, Length: 9, Language: "sh"}},
+			want:     "Run:\n```sh\nmake test\n```",
+		},
+		{
+			name:     "hidden link",
+			text:     "Apply here",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityTextURL{Offset: 6, Length: 4, URL: "https://example.com"}},
+			want:     "Apply [here](https://example.com)",
+		},
+		{
+			name:     "mention without username",
+			text:     "Ask Anna",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityMentionName{Offset: 4, Length: 4, UserID: 42}},
+			want:     "Ask [Anna](tg://user?id=42)",
+		},
+		{
+			name:     "UTF-16 offsets after emoji",
+			text:     "🔥 Вакансия",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 3, Length: 8}},
+			want:     "🔥 **Вакансия**",
+		},
+		{
+			name:     "readable entities kept",
+			text:     "See https://example.com #go",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityURL{Offset: 4, Length: 19}, &tg.MessageEntityHashtag{Offset: 24, Length: 3}},
+			want:     "See https://example.com #go",
+		},
+		{
+			name:     "out of range",
+			text:     "Short",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 2, Length: 10}},
+			want:     "Short",
+		},
+		{
+			name:     "whitespace only",
+			text:     "a   b",
+			entities: []tg.MessageEntityClass{&tg.MessageEntityBold{Offset: 1, Length: 3}},
+			want:     "a   b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FromTelegram(tt.text, tt.entities); got != tt.want {
+				t.Fatalf("FromTelegram() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package parser
+
+import (
+	"context"
+	"time"
+
+	"svpb-tmpl/pkg/indexer"
+	"svpb-tmpl/pkg/ingest"
+
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// albumWait is how long album messages are buffered after the last one arrives.
+// Telegram delivers the messages of an album as separate updates in quick succession.
+const albumWait = 2 * time.Second
+
+type albumKey struct {
+	chatID    int64
+	groupedID int64
+}
+
+type pendingAlbum struct {
+	msgs  []*tg.Message
+	timer *time.Timer
+}
+
+// bufferAlbum holds an album message until no more messages of the album arrive
+// for albumWait, then indexes the whole album as one post.
+func (h *Handler) bufferAlbum(ctx context.Context, msg *tg.Message, chatID int64) {
+	key := albumKey{chatID: chatID, groupedID: msg.GroupedID}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if pending, ok := h.albums[key]; ok {
+		pending.msgs = append(pending.msgs, msg)
+		pending.timer.Reset(albumWait)
+		return
+	}
+
+	h.albums[key] = &pendingAlbum{
+		msgs:  []*tg.Message{msg},
+		timer: time.AfterFunc(albumWait, func() { h.flushAlbum(ctx, key) }),
+	}
+}
+
+// flushAlbum indexes a buffered album.
+func (h *Handler) flushAlbum(ctx context.Context, key albumKey) {
+	h.mu.Lock()
+	pending, ok := h.albums[key]
+	delete(h.albums, key)
+	h.mu.Unlock()
+
+	if !ok || ctx.Err() != nil {
+		return
+	}
+
+	if err := h.submit(ctx, &ingest.Job{Kind: ingest.KindIndex, ChatID: key.chatID, Messages: pending.msgs}); err != nil {
+		h.logger.Error("Failed to index album",
+			zap.Error(err),
+			zap.Int64("chatId", key.chatID),
+			zap.Int64("groupedId", key.groupedID),
+		)
+	}
+}
+
+// indexAlbum indexes messages of the same album, merging them into its chunk.
+func (h *Handler) indexAlbum(ctx context.Context, msgs []*tg.Message, chatID int64) error {
+	h.logger.Info("Processing album",
+		zap.Int64("chatId", chatID),
+		zap.Int64("groupedId", msgs[0].GroupedID),
+		zap.Int("messages", len(msgs)),
+	)
+
+	posts := make([]indexer.Post, 0, len(msgs))
+	for _, msg := range msgs {
+		post, err := h.buildPost(ctx, msg, chatID)
+		if err != nil {
+			return err
+		}
+		posts = append(posts, post)
+	}
+
+	return h.indexer.IndexAlbum(ctx, chatID, msgs[0].GroupedID, posts)
+}
@@ -0,0 +1,218 @@
+package parser
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+const cursorsCollection = "backfill_cursors"
+
+// BackfillOptions controls how chat history is walked.
+type BackfillOptions struct {
+	BatchSize int // Messages per messages.getHistory call
+	Limit     int // Max messages per chat (0 = whole history)
+	Workers   int // Messages of a page handled concurrently (albums stay together)
+}
+
+// CursorStore persists per-chat backfill progress in PocketBase.
+type CursorStore struct {
+	app core.App
+}
+
+// NewCursorStore creates a cursor store backed by the backfill_cursors collection.
+func NewCursorStore(app core.App) *CursorStore {
+	return &CursorStore{app: app}
+}
+
+// Get returns the ID of the last message processed for the chat (0 if none).
+func (s *CursorStore) Get(chatID int64) (int, error) {
+	record, err := s.find(chatID)
+	if err != nil {
+		return 0, err
+	}
+	if record == nil {
+		return 0, nil
+	}
+	return record.GetInt("lastMsgId"), nil
+}
+
+// Set records msgID as the last message processed for the chat.
+func (s *CursorStore) Set(chatID int64, msgID int) error {
+	record, err := s.find(chatID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		collection, err := s.app.FindCollectionByNameOrId(cursorsCollection)
+		if err != nil {
+			return fmt.Errorf("%s collection not found: %w", cursorsCollection, err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("chatId", fmt.Sprintf("%d", chatID))
+	}
+	record.Set("lastMsgId", msgID)
+	return s.app.Save(record)
+}
+
+func (s *CursorStore) find(chatID int64) (*core.Record, error) {
+	record, err := s.app.FindFirstRecordByFilter(cursorsCollection, "chatId = {:chatId}", dbx.Params{
+		"chatId": fmt.Sprintf("%d", chatID),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// Backfill walks the history of every chat in chatIDs from the oldest message to the newest
+// and passes each message to handler. Progress is saved to cursors after every message, so an
+// interrupted run resumes where it stopped. Must be called from within Run.
+func (c *Client) Backfill(ctx context.Context, chatIDs []int64, cursors *CursorStore, opts BackfillOptions, handler MessageHandler) error {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+
+	peers, err := c.resolvePeers(ctx, chatIDs)
+	if err != nil {
+		return err
+	}
+
+	for _, chatID := range chatIDs {
+		peer, ok := peers[chatID]
+		if !ok {
+			c.logger.Warn("Chat not found among dialogs, skipping", zap.Int64("chatId", chatID))
+			continue
+		}
+
+		if err := c.backfillChat(ctx, chatID, peer, cursors, opts, handler); err != nil {
+			return fmt.Errorf("backfill chat %d: %w", chatID, err)
+		}
+	}
+
+	return nil
+}
+
+// backfillChat pages through a single chat's history in ascending order, starting after the saved cursor.
+func (c *Client) backfillChat(ctx context.Context, chatID int64, peer tg.InputPeerClass, cursors *CursorStore, opts BackfillOptions, handler MessageHandler) error {
+	cursor, err := cursors.Get(chatID)
+	if err != nil {
+		return fmt.Errorf("failed to load cursor: %w", err)
+	}
+
+	c.logger.Info("Backfilling chat", zap.Int64("chatId", chatID), zap.Int("fromMsgId", cursor))
+
+	processed := 0
+	for opts.Limit == 0 || processed < opts.Limit {
+		// offset_id + negative add_offset pages forward: only messages newer than the cursor are returned.
+		res, err := c.client.API().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:      peer,
+			OffsetID:  cursor + 1,
+			AddOffset: -opts.BatchSize,
+			Limit:     opts.BatchSize,
+			MinID:     cursor,
+		})
+		if err != nil {
+			if d, ok := tgerr.AsFloodWait(err); ok {
+				c.logger.Warn("Flood wait, sleeping", zap.Duration("duration", d))
+				select {
+				case <-time.After(d):
+					continue
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			return fmt.Errorf("failed to get history: %w", err)
+		}
+
+		modified, ok := res.AsModified()
+		if !ok {
+			break
+		}
+
+		c.notifyChannels(tg.ChatClassArray(modified.GetChats()).ChannelToMap())
+
+		batch := modified.GetMessages()
+		sort.Slice(batch, func(i, j int) bool { return batch[i].GetID() < batch[j].GetID() })
+
+		var page []tg.MessageClass
+		for _, m := range batch {
+			if m.GetID() <= cursor {
+				continue
+			}
+			if opts.Limit != 0 && processed+len(page) >= opts.Limit {
+				break
+			}
+			page = append(page, m)
+		}
+		if len(page) == 0 {
+			break
+		}
+
+		// The cursor advances per page: after a failure the page is handled again,
+		// skipping the messages that were already indexed.
+		if err := handlePage(ctx, chatID, page, opts.Workers, handler); err != nil {
+			return err
+		}
+
+		cursor = page[len(page)-1].GetID()
+		processed += len(page)
+		if err := cursors.Set(chatID, cursor); err != nil {
+			return fmt.Errorf("failed to save cursor: %w", err)
+		}
+	}
+
+	c.logger.Info("Chat backfilled",
+		zap.Int64("chatId", chatID),
+		zap.Int("messages", processed),
+		zap.Int("lastMsgId", cursor),
+	)
+
+	return nil
+}
+
+// handlePage passes the messages of a history page to handler, up to workers at a time,
+// so the indexer can batch them. Messages of an album are handled in order by the same worker.
+func handlePage(ctx context.Context, chatID int64, page []tg.MessageClass, workers int, handler MessageHandler) error {
+	var units [][]*tg.Message
+	albums := make(map[int64]int)
+	for _, m := range page {
+		// Service and empty messages only advance the cursor.
+		msg, ok := m.(*tg.Message)
+		if !ok {
+			continue
+		}
+		if groupedID, ok := msg.GetGroupedID(); ok {
+			if i, seen := albums[groupedID]; seen {
+				units[i] = append(units[i], msg)
+				continue
+			}
+			albums[groupedID] = len(units)
+		}
+		units = append(units, []*tg.Message{msg})
+	}
+
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(max(workers, 1))
+	for _, unit := range units {
+		g.Go(func() error {
+			for _, msg := range unit {
+				if err := handler(ctx, msg, chatID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	return g.Wait()
+}
@@ -7,9 +7,11 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/telegram/auth"
+	"github.com/gotd/td/telegram/updates"
 	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
 )
@@ -20,6 +22,10 @@ type Config struct {
 	APIHash     string
 	Phone       string
 	SessionPath string // Path to session.json file
+
+	// Update state storage for gap recovery (in-memory, i.e. lost on restart, if nil)
+	State           *StateStorage
+	TrackedChannels []int64 // Channels whose pts are tracked from startup (see Client.TrackChannels)
 }
 
 func LoadConfigFromEnv() Config {
@@ -44,6 +50,14 @@ type Client struct {
 	client     *telegram.Client
 	logger     *zap.Logger
 	dispatcher tg.UpdateDispatcher
+	gaps       *updates.Manager
+	state      *StateStorage
+	onStart    []func(ctx context.Context)
+	onChannels []func(channels map[int64]*tg.Channel)
+	onDirect   []DirectMessageHandler
+
+	trackMu sync.Mutex
+	selfID  int64 // set once Start is connected
 }
 
 func NewClient(cfg Config, logger *zap.Logger) *Client {
@@ -53,10 +67,24 @@ func NewClient(cfg Config, logger *zap.Logger) *Client {
 
 	dispatcher := tg.NewUpdateDispatcher()
 
+	// The updates manager tracks pts/qts and fetches differences to fill gaps
+	gapsCfg := updates.Config{
+		Handler: dispatcher,
+		Logger:  logger.Named("updates"),
+		OnChannelTooLong: func(channelID int64) {
+			logger.Warn("Channel difference too long, run tg-backfill to recover it", zap.Int64("channelId", channelID))
+		},
+	}
+	if cfg.State != nil {
+		gapsCfg.Storage = cfg.State
+		gapsCfg.AccessHasher = cfg.State
+	}
+	gaps := updates.New(gapsCfg)
+
 	client := telegram.NewClient(cfg.APIID, cfg.APIHash, telegram.Options{
 		Logger:         logger,
 		SessionStorage: &telegram.FileSessionStorage{Path: cfg.SessionPath},
-		UpdateHandler:  dispatcher,
+		UpdateHandler:  gaps,
 		Device: telegram.DeviceConfig{
 			DeviceModel:    "Desktop",
 			SystemVersion:  "Windows 10",
@@ -71,6 +99,8 @@ func NewClient(cfg Config, logger *zap.Logger) *Client {
 		client:     client,
 		logger:     logger,
 		dispatcher: dispatcher,
+		gaps:       gaps,
+		state:      cfg.State,
 	}
 }
 
@@ -136,9 +166,14 @@ func (c *Client) Login(ctx context.Context) error {
 	})
 }
 
-func (c *Client) OnNewMessage(handler func(ctx context.Context, msg *tg.Message, peerID int64) error) {
+// MessageHandler processes a single Telegram message from the given peer.
+type MessageHandler func(ctx context.Context, msg *tg.Message, peerID int64) error
+
+func (c *Client) OnNewMessage(handler MessageHandler) {
 	// Channels and Supergroups
 	c.dispatcher.OnNewChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateNewChannelMessage) error {
+		c.notifyChannels(e.Channels)
+
 		msg, ok := update.Message.(*tg.Message)
 		if !ok {
 			return nil
@@ -165,6 +200,14 @@ func (c *Client) OnNewMessage(handler func(ctx context.Context, msg *tg.Message,
 			peerID = p.ChatID
 		case *tg.PeerUser:
 			peerID = p.UserID
+			if user, ok := e.Users[p.UserID]; ok && !msg.Out {
+				c.notifyDirect(ctx, DirectMessage{
+					UserID:     user.ID,
+					AccessHash: user.AccessHash,
+					Username:   user.Username,
+					Text:       msg.Message,
+				})
+			}
 		default:
 			return nil
 		}
@@ -173,6 +216,88 @@ func (c *Client) OnNewMessage(handler func(ctx context.Context, msg *tg.Message,
 	})
 }
 
+// DirectMessage is a private message sent to the account.
+type DirectMessage struct {
+	UserID     int64
+	AccessHash int64 // of the sender, to reply with SendMessageToUser
+	Username   string
+	Text       string
+}
+
+// DirectMessageHandler processes a private message sent to the account.
+type DirectMessageHandler func(ctx context.Context, msg DirectMessage) error
+
+// OnDirectMessage registers handler for the private messages sent to the account, which
+// are passed to it before the OnNewMessage handler.
+func (c *Client) OnDirectMessage(handler DirectMessageHandler) {
+	c.onDirect = append(c.onDirect, handler)
+}
+
+func (c *Client) notifyDirect(ctx context.Context, msg DirectMessage) {
+	for _, handler := range c.onDirect {
+		if err := handler(ctx, msg); err != nil {
+			c.logger.Warn("Failed to handle direct message", zap.Int64("userId", msg.UserID), zap.Error(err))
+		}
+	}
+}
+
+// OnEditMessage registers handler for edited messages in channels, groups and private chats.
+func (c *Client) OnEditMessage(handler MessageHandler) {
+	c.dispatcher.OnEditChannelMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditChannelMessage) error {
+		c.notifyChannels(e.Channels)
+		return dispatchMessage(ctx, update.Message, handler)
+	})
+
+	c.dispatcher.OnEditMessage(func(ctx context.Context, e tg.Entities, update *tg.UpdateEditMessage) error {
+		return dispatchMessage(ctx, update.Message, handler)
+	})
+}
+
+// OnChannels registers f to receive the channel entities attached to incoming messages,
+// before the messages themselves are handled.
+func (c *Client) OnChannels(f func(channels map[int64]*tg.Channel)) {
+	c.onChannels = append(c.onChannels, f)
+}
+
+func (c *Client) notifyChannels(channels map[int64]*tg.Channel) {
+	if len(channels) == 0 {
+		return
+	}
+	for _, f := range c.onChannels {
+		f(channels)
+	}
+}
+
+// DeleteHandler processes message deletions. chatID is 0 for legacy groups and
+// private chats, where Telegram does not say which chat the messages belonged to.
+type DeleteHandler func(ctx context.Context, chatID int64, msgIDs []int) error
+
+// OnDeleteMessages registers handler for deleted messages.
+func (c *Client) OnDeleteMessages(handler DeleteHandler) {
+	c.dispatcher.OnDeleteChannelMessages(func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteChannelMessages) error {
+		return handler(ctx, update.ChannelID, update.Messages)
+	})
+
+	c.dispatcher.OnDeleteMessages(func(ctx context.Context, e tg.Entities, update *tg.UpdateDeleteMessages) error {
+		return handler(ctx, 0, update.Messages)
+	})
+}
+
+// dispatchMessage passes a regular message to handler along with its bare peer ID.
+func dispatchMessage(ctx context.Context, m tg.MessageClass, handler MessageHandler) error {
+	msg, ok := m.(*tg.Message)
+	if !ok {
+		return nil
+	}
+
+	id, ok := peerID(msg.PeerID)
+	if !ok {
+		return nil
+	}
+
+	return handler(ctx, msg, id)
+}
+
 // SendMessageToSelf sends a formatted message to your "Saved Messages".
 func (c *Client) SendMessageToSelf(ctx context.Context, text string) error {
 	randomID, err := c.client.RandInt64()
@@ -187,9 +312,83 @@ func (c *Client) SendMessageToSelf(ctx context.Context, text string) error {
 	return err	
 }
 
+// SendMessageToUser sends a message to a user, by ID and the access hash the account
+// got for them (e.g. from a message they sent).
+func (c *Client) SendMessageToUser(ctx context.Context, userID, accessHash int64, text string) error {
+	randomID, err := c.client.RandInt64()
+	if err != nil {
+		return fmt.Errorf("failed to generate random ID: %w", err)
+	}
+	_, err = c.client.API().MessagesSendMessage(ctx, &tg.MessagesSendMessageRequest{
+		Peer:     &tg.InputPeerUser{UserID: userID, AccessHash: accessHash},
+		Message:  text,
+		RandomID: randomID,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send message to user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// Start listens for updates until ctx is cancelled. With a persistent State, updates
+// missed while the client was offline are fetched on startup and passed to the handlers.
 func (c *Client) Start(ctx context.Context) error {
+	return c.Run(ctx, func(ctx context.Context) error {
+		self, err := c.client.Self(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get self: %w", err)
+		}
+
+		c.trackMu.Lock()
+		c.selfID = self.ID
+		tracked := c.config.TrackedChannels
+		c.trackMu.Unlock()
+
+		if c.state != nil {
+			if err := c.trackChannels(ctx, self.ID, tracked); err != nil {
+				c.logger.Warn("Failed to track channels", zap.Error(err))
+			}
+		}
+
+		return c.gaps.Run(ctx, c.client.API(), self.ID, updates.AuthOptions{
+			IsBot: self.Bot,
+			OnStart: func(ctx context.Context) {
+				c.logger.Info("Listening for updates")
+				for _, f := range c.onStart {
+					go f(ctx)
+				}
+			},
+		})
+	})
+}
+
+// TrackChannels sets the channels whose pts are tracked, e.g. after sources are added at
+// runtime, so their gaps are recovered after downtime too. Before Start is connected it
+// replaces Config.TrackedChannels. It is a no-op without a persistent State.
+func (c *Client) TrackChannels(ctx context.Context, channelIDs []int64) error {
+	c.trackMu.Lock()
+	selfID := c.selfID
+	if selfID == 0 {
+		c.config.TrackedChannels = channelIDs
+	}
+	c.trackMu.Unlock()
+
+	if selfID == 0 || c.state == nil {
+		return nil
+	}
+	return c.trackChannels(ctx, selfID, channelIDs)
+}
+
+// OnStart registers f to be run in the background once Start is listening for updates.
+// ctx is cancelled when the client stops.
+func (c *Client) OnStart(f func(ctx context.Context)) {
+	c.onStart = append(c.onStart, f)
+}
+
+// Run connects to Telegram, verifies the saved session and calls f with the
+// authorized client. The connection is closed when f returns.
+func (c *Client) Run(ctx context.Context, f func(ctx context.Context) error) error {
 	return c.client.Run(ctx, func(ctx context.Context) error {
-		
 		status, err := c.client.Auth().Status(ctx)
 		if err != nil {
 			return fmt.Errorf("failed to get auth status: %w", err)
@@ -209,8 +408,7 @@ func (c *Client) Start(ctx context.Context) error {
 			zap.Int64("user_id", self.ID),
 		)
 
-		<-ctx.Done()
-		return ctx.Err()
+		return f(ctx)
 	})
 }
 
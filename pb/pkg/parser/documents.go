@@ -0,0 +1,85 @@
+package parser
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"svpb-tmpl/pkg/extract"
+
+	"github.com/gotd/td/telegram/downloader"
+	"github.com/gotd/td/tg"
+)
+
+// ErrUnreadable is returned for attachments whose text cannot be extracted (malformed or
+// hostile files): retrying won't help, unlike a failed download.
+var ErrUnreadable = errors.New("unreadable document")
+
+// Document is a text attachment extracted from a message.
+type Document struct {
+	Name     string `json:"name"`
+	MimeType string `json:"mimeType"`
+	Size     int64  `json:"size"`
+	Text     string `json:"-"`
+}
+
+// DocumentReader downloads supported document attachments and extracts their text.
+type DocumentReader struct {
+	api        *tg.Client
+	downloader *downloader.Downloader
+	maxSize    int64
+}
+
+// NewDocumentReader creates a reader that skips attachments larger than maxSize bytes.
+func NewDocumentReader(api *tg.Client, maxSize int64) *DocumentReader {
+	return &DocumentReader{
+		api:        api,
+		downloader: downloader.NewDownloader(),
+		maxSize:    maxSize,
+	}
+}
+
+// Read returns the text of the message's document attachment, or nil if the message
+// has no attachment or it is of an unsupported type or too large.
+func (r *DocumentReader) Read(ctx context.Context, msg *tg.Message) (*Document, error) {
+	media, ok := msg.Media.(*tg.MessageMediaDocument)
+	if !ok {
+		return nil, nil
+	}
+	doc, ok := media.Document.(*tg.Document)
+	if !ok {
+		return nil, nil
+	}
+
+	name := documentName(doc)
+	if !extract.Supported(doc.MimeType, name) || doc.Size > r.maxSize {
+		return nil, nil
+	}
+
+	var buf bytes.Buffer
+	if _, err := r.downloader.Download(r.api, doc.AsInputDocumentFileLocation()).Stream(ctx, &buf); err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+
+	text, err := extract.Text(buf.Bytes(), doc.MimeType, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract text from %s: %w: %w", name, ErrUnreadable, err)
+	}
+
+	return &Document{
+		Name:     name,
+		MimeType: doc.MimeType,
+		Size:     doc.Size,
+		Text:     text,
+	}, nil
+}
+
+func documentName(doc *tg.Document) string {
+	for _, attr := range doc.Attributes {
+		if a, ok := attr.(*tg.DocumentAttributeFilename); ok {
+			return a.FileName
+		}
+	}
+	return ""
+}
@@ -2,10 +2,15 @@ package parser
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
 
 	"svpb-tmpl/pkg/config"
 	"svpb-tmpl/pkg/indexer"
+	"svpb-tmpl/pkg/ingest"
+	"svpb-tmpl/pkg/sources"
 
 	"github.com/gotd/td/tg"
 	"go.uber.org/zap"
@@ -14,31 +19,42 @@ import (
 // Handler processes incoming Telegram messages and indexes them.
 type Handler struct {
 	cfg     *config.Config
+	sources *sources.Registry
 	indexer *indexer.Service
+	docs    *DocumentReader
+	queue   *ingest.Queue
 	logger  *zap.Logger
+
+	mu     sync.Mutex
+	albums map[albumKey]*pendingAlbum // album messages waiting to be merged
 }
 
 // NewHandler creates a new message handler.
-func NewHandler(cfg *config.Config, indexerSvc *indexer.Service, logger *zap.Logger) *Handler {
+// Without a queue, messages are indexed synchronously.
+func NewHandler(cfg *config.Config, sourcesReg *sources.Registry, indexerSvc *indexer.Service, docs *DocumentReader, queue *ingest.Queue, logger *zap.Logger) *Handler {
 	return &Handler{
 		cfg:     cfg,
+		sources: sourcesReg,
 		indexer: indexerSvc,
+		docs:    docs,
+		queue:   queue,
 		logger:  logger,
+		albums:  make(map[albumKey]*pendingAlbum),
 	}
 }
 
 // HandleMessage processes an incoming Telegram message.
 func (h *Handler) HandleMessage(ctx context.Context, msg *tg.Message, chatID int64) error {
-	// Skip empty messages
-	if msg.Message == "" {
+	// Skip messages without text or attachments
+	if msg.Message == "" && msg.Media == nil {
 		return nil
 	}
 
 	fmt.Printf("New Message from Chat ID: %d\n", chatID) 
 
-	// Check if chat is in whitelist
-	if !h.cfg.IsChatAllowed(chatID) {
-		h.logger.Debug("Message from non-whitelisted chat, skipping",
+	// Check if chat is an enabled source
+	if !h.sources.IsAllowed(chatID) {
+		h.logger.Debug("Message from unknown source, skipping",
 			zap.Int64("chatId", chatID),
 			zap.Int("msgId", msg.ID),
 		)
@@ -51,8 +67,14 @@ func (h *Handler) HandleMessage(ctx context.Context, msg *tg.Message, chatID int
 		zap.Int("textLength", len(msg.Message)),
 	)
 
+	// Albums arrive as several messages, index them together
+	if msg.GroupedID != 0 {
+		h.bufferAlbum(ctx, msg, chatID)
+		return nil
+	}
+
 	// Index the message
-	if err := h.indexer.IndexMessage(ctx, msg, chatID); err != nil {
+	if err := h.submit(ctx, &ingest.Job{Kind: ingest.KindIndex, ChatID: chatID, Messages: []*tg.Message{msg}}); err != nil {
 		h.logger.Error("Failed to index message",
 			zap.Error(err),
 			zap.Int64("chatId", chatID),
@@ -63,3 +85,130 @@ func (h *Handler) HandleMessage(ctx context.Context, msg *tg.Message, chatID int
 
 	return nil
 }
+
+// Index prepares and indexes a message without checking the sources whitelist,
+// returning indexing errors to the caller (used by backfills). Album messages are
+// merged into their album's chunk one by one.
+func (h *Handler) Index(ctx context.Context, msg *tg.Message, chatID int64) error {
+	if msg.GroupedID != 0 {
+		return h.indexAlbum(ctx, []*tg.Message{msg}, chatID)
+	}
+	post, err := h.buildPost(ctx, msg, chatID)
+	if err != nil {
+		return err
+	}
+	return h.indexer.IndexMessage(ctx, post)
+}
+
+// buildPost collects the text to index: the message text (or media caption)
+// followed by the text extracted from a document attachment, if any. Download
+// errors are returned so the job is retried; unreadable documents are skipped.
+func (h *Handler) buildPost(ctx context.Context, msg *tg.Message, chatID int64) (indexer.Post, error) {
+	post := indexer.NewPost(msg, chatID)
+	if h.docs == nil {
+		return post, nil
+	}
+
+	doc, err := h.docs.Read(ctx, msg)
+	if errors.Is(err, ErrUnreadable) {
+		h.logger.Warn("Failed to read document, indexing caption only",
+			zap.Error(err),
+			zap.Int64("chatId", chatID),
+			zap.Int("msgId", msg.ID),
+		)
+		return post, nil
+	}
+	if err != nil {
+		return post, err
+	}
+	if doc == nil || doc.Text == "" {
+		return post, nil
+	}
+
+	post.Text = strings.TrimSpace(post.Text + "\n\n" + doc.Text)
+	post.Meta = map[string]interface{}{"document": doc}
+	return post, nil
+}
+
+// HandleEdit re-indexes an edited Telegram message.
+func (h *Handler) HandleEdit(ctx context.Context, msg *tg.Message, chatID int64) error {
+	if !h.sources.IsAllowed(chatID) {
+		return nil
+	}
+
+	h.logger.Info("Processing edited message",
+		zap.Int64("chatId", chatID),
+		zap.Int("msgId", msg.ID),
+	)
+
+	if err := h.submit(ctx, &ingest.Job{Kind: ingest.KindEdit, ChatID: chatID, Messages: []*tg.Message{msg}}); err != nil {
+		h.logger.Error("Failed to re-index edited message",
+			zap.Error(err),
+			zap.Int64("chatId", chatID),
+			zap.Int("msgId", msg.ID),
+		)
+	}
+
+	return nil
+}
+
+// HandleDelete removes deleted Telegram messages from the index.
+func (h *Handler) HandleDelete(ctx context.Context, chatID int64, msgIDs []int) error {
+	if chatID != 0 && !h.sources.IsAllowed(chatID) {
+		return nil
+	}
+
+	h.logger.Info("Processing deleted messages",
+		zap.Int64("chatId", chatID),
+		zap.Ints("msgIds", msgIDs),
+	)
+
+	if err := h.submit(ctx, &ingest.Job{Kind: ingest.KindDelete, ChatID: chatID, MsgIDs: msgIDs}); err != nil {
+		h.logger.Error("Failed to remove deleted messages",
+			zap.Error(err),
+			zap.Int64("chatId", chatID),
+			zap.Ints("msgIds", msgIDs),
+		)
+	}
+
+	return nil
+}
+
+// submit enqueues a job, or processes it right away when the handler has no queue.
+func (h *Handler) submit(ctx context.Context, job *ingest.Job) error {
+	if h.queue == nil {
+		return h.Process(ctx, job)
+	}
+	return h.queue.Enqueue(job)
+}
+
+// Process performs an ingestion job. Errors are returned so the queue can retry the job.
+func (h *Handler) Process(ctx context.Context, job *ingest.Job) error {
+	switch job.Kind {
+	case ingest.KindIndex:
+		if len(job.Messages) == 0 {
+			return nil
+		}
+		if job.Messages[0].GroupedID != 0 {
+			return h.indexAlbum(ctx, job.Messages, job.ChatID)
+		}
+		return h.Index(ctx, job.Messages[0], job.ChatID)
+	case ingest.KindEdit:
+		if len(job.Messages) == 0 {
+			return nil
+		}
+		msg := job.Messages[0]
+		if msg.GroupedID != 0 {
+			return h.indexAlbum(ctx, job.Messages, job.ChatID)
+		}
+		post, err := h.buildPost(ctx, msg, job.ChatID)
+		if err != nil {
+			return err
+		}
+		return h.indexer.UpdateMessage(ctx, post)
+	case ingest.KindDelete:
+		return h.indexer.DeleteMessages(ctx, job.ChatID, job.MsgIDs)
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
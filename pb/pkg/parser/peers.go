@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/tg"
+	"go.uber.org/zap"
+)
+
+// peerID returns the bare ID of a peer, matching the IDs passed to message handlers.
+func peerID(p tg.PeerClass) (int64, bool) {
+	switch p := p.(type) {
+	case *tg.PeerChannel:
+		return p.ChannelID, true
+	case *tg.PeerChat:
+		return p.ChatID, true
+	case *tg.PeerUser:
+		return p.UserID, true
+	default:
+		return 0, false
+	}
+}
+
+// inputPeerID returns the bare ID of an input peer.
+func inputPeerID(p tg.InputPeerClass) (int64, bool) {
+	switch p := p.(type) {
+	case *tg.InputPeerChannel:
+		return p.ChannelID, true
+	case *tg.InputPeerChat:
+		return p.ChatID, true
+	case *tg.InputPeerUser:
+		return p.UserID, true
+	default:
+		return 0, false
+	}
+}
+
+// resolvePeers walks the account's dialogs and returns input peers (with access hashes)
+// for the requested chat IDs. IDs not found among the dialogs are omitted.
+func (c *Client) resolvePeers(ctx context.Context, chatIDs []int64) (map[int64]tg.InputPeerClass, error) {
+	wanted := make(map[int64]bool, len(chatIDs))
+	for _, id := range chatIDs {
+		wanted[id] = true
+	}
+
+	peers := make(map[int64]tg.InputPeerClass, len(chatIDs))
+	iter := query.GetDialogs(c.client.API()).BatchSize(100).Iter()
+	for iter.Next(ctx) {
+		elem := iter.Value()
+		id, ok := inputPeerID(elem.Peer)
+		if !ok || !wanted[id] {
+			continue
+		}
+		peers[id] = elem.Peer
+		if len(peers) == len(wanted) {
+			break
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate dialogs: %w", err)
+	}
+
+	return peers, nil
+}
+
+// Channels returns all channels among the account's dialogs, keyed by channel ID.
+func (c *Client) Channels(ctx context.Context) (map[int64]*tg.Channel, error) {
+	channels := make(map[int64]*tg.Channel)
+	iter := query.GetDialogs(c.client.API()).BatchSize(100).Iter()
+	for iter.Next(ctx) {
+		for id, channel := range iter.Value().Entities.Channels() {
+			channels[id] = channel
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate dialogs: %w", err)
+	}
+
+	return channels, nil
+}
+
+// ResolveSource resolves a public @username to a peer ID and title. Channels the account
+// is not a member of are joined, so their updates start arriving.
+func (c *Client) ResolveSource(ctx context.Context, username string) (int64, string, error) {
+	res, err := c.client.API().ContactsResolveUsername(ctx, &tg.ContactsResolveUsernameRequest{
+		Username: strings.TrimPrefix(username, "@"),
+	})
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to resolve @%s: %w", username, err)
+	}
+
+	switch p := res.Peer.(type) {
+	case *tg.PeerChannel:
+		for _, chat := range res.Chats {
+			channel, ok := chat.(*tg.Channel)
+			if !ok || channel.ID != p.ChannelID {
+				continue
+			}
+			if channel.Left {
+				if _, err := c.client.API().ChannelsJoinChannel(ctx, channel.AsInput()); err != nil {
+					return 0, "", fmt.Errorf("failed to join @%s: %w", username, err)
+				}
+				c.logger.Info("Joined channel", zap.String("username", username), zap.Int64("channelId", channel.ID))
+			}
+			return channel.ID, channel.Title, nil
+		}
+	case *tg.PeerUser:
+		for _, u := range res.Users {
+			user, ok := u.(*tg.User)
+			if !ok || user.ID != p.UserID {
+				continue
+			}
+			return user.ID, strings.TrimSpace(user.FirstName + " " + user.LastName), nil
+		}
+	}
+
+	return 0, "", fmt.Errorf("@%s is not a channel or user", username)
+}
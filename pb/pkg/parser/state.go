@@ -0,0 +1,280 @@
+package parser
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/gotd/td/telegram/query"
+	"github.com/gotd/td/telegram/updates"
+	"github.com/gotd/td/tg"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+const (
+	stateCollection    = "tg_state"
+	channelsCollection = "tg_channels"
+)
+
+var errStateNotFound = errors.New("update state not found")
+
+// StateStorage persists the MTProto update state (pts/qts/date/seq) and per-channel
+// pts and access hashes in PocketBase, so missed updates can be recovered after a restart.
+// It implements updates.StateStorage and updates.ChannelAccessHasher.
+type StateStorage struct {
+	app core.App
+	mu  sync.Mutex
+}
+
+var (
+	_ updates.StateStorage        = (*StateStorage)(nil)
+	_ updates.ChannelAccessHasher = (*StateStorage)(nil)
+)
+
+// NewStateStorage creates a state storage backed by the tg_state and tg_channels collections.
+func NewStateStorage(app core.App) *StateStorage {
+	return &StateStorage{app: app}
+}
+
+// GetState implements updates.StateStorage.
+func (s *StateStorage) GetState(_ context.Context, userID int64) (updates.State, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.findState(userID)
+	if err != nil || record == nil {
+		return updates.State{}, false, err
+	}
+
+	return updates.State{
+		Pts:  record.GetInt("pts"),
+		Qts:  record.GetInt("qts"),
+		Date: record.GetInt("date"),
+		Seq:  record.GetInt("seq"),
+	}, true, nil
+}
+
+// SetState implements updates.StateStorage.
+func (s *StateStorage) SetState(_ context.Context, userID int64, state updates.State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.findState(userID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		collection, err := s.app.FindCollectionByNameOrId(stateCollection)
+		if err != nil {
+			return fmt.Errorf("%s collection not found: %w", stateCollection, err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("userId", strconv.FormatInt(userID, 10))
+	}
+
+	record.Set("pts", state.Pts)
+	record.Set("qts", state.Qts)
+	record.Set("date", state.Date)
+	record.Set("seq", state.Seq)
+	return s.app.Save(record)
+}
+
+// SetPts implements updates.StateStorage.
+func (s *StateStorage) SetPts(_ context.Context, userID int64, pts int) error {
+	return s.updateState(userID, map[string]interface{}{"pts": pts})
+}
+
+// SetQts implements updates.StateStorage.
+func (s *StateStorage) SetQts(_ context.Context, userID int64, qts int) error {
+	return s.updateState(userID, map[string]interface{}{"qts": qts})
+}
+
+// SetDate implements updates.StateStorage.
+func (s *StateStorage) SetDate(_ context.Context, userID int64, date int) error {
+	return s.updateState(userID, map[string]interface{}{"date": date})
+}
+
+// SetSeq implements updates.StateStorage.
+func (s *StateStorage) SetSeq(_ context.Context, userID int64, seq int) error {
+	return s.updateState(userID, map[string]interface{}{"seq": seq})
+}
+
+// SetDateSeq implements updates.StateStorage.
+func (s *StateStorage) SetDateSeq(_ context.Context, userID int64, date, seq int) error {
+	return s.updateState(userID, map[string]interface{}{"date": date, "seq": seq})
+}
+
+// GetChannelPts implements updates.StateStorage.
+func (s *StateStorage) GetChannelPts(_ context.Context, userID, channelID int64) (int, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.findChannel(userID, channelID)
+	if err != nil || record == nil {
+		return 0, false, err
+	}
+	return record.GetInt("pts"), true, nil
+}
+
+// SetChannelPts implements updates.StateStorage.
+func (s *StateStorage) SetChannelPts(_ context.Context, userID, channelID int64, pts int) error {
+	return s.upsertChannel(userID, channelID, map[string]interface{}{"pts": pts})
+}
+
+// ForEachChannels implements updates.StateStorage.
+func (s *StateStorage) ForEachChannels(ctx context.Context, userID int64, f func(ctx context.Context, channelID int64, pts int) error) error {
+	s.mu.Lock()
+	records, err := s.app.FindAllRecords(channelsCollection, dbx.HashExp{"userId": strconv.FormatInt(userID, 10)})
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	for _, record := range records {
+		channelID, err := strconv.ParseInt(record.GetString("channelId"), 10, 64)
+		if err != nil {
+			continue
+		}
+		if err := f(ctx, channelID, record.GetInt("pts")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetChannelAccessHash implements updates.ChannelAccessHasher.
+func (s *StateStorage) SetChannelAccessHash(_ context.Context, userID, channelID, accessHash int64) error {
+	// Access hashes are stored as text: they don't fit into a float64 number field.
+	return s.upsertChannel(userID, channelID, map[string]interface{}{"accessHash": strconv.FormatInt(accessHash, 10)})
+}
+
+// GetChannelAccessHash implements updates.ChannelAccessHasher.
+func (s *StateStorage) GetChannelAccessHash(_ context.Context, userID, channelID int64) (int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.findChannel(userID, channelID)
+	if err != nil || record == nil || record.GetString("accessHash") == "" {
+		return 0, false, err
+	}
+
+	accessHash, err := strconv.ParseInt(record.GetString("accessHash"), 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid access hash for channel %d: %w", channelID, err)
+	}
+	return accessHash, true, nil
+}
+
+func (s *StateStorage) updateState(userID int64, values map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.findState(userID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		return errStateNotFound
+	}
+
+	for k, v := range values {
+		record.Set(k, v)
+	}
+	return s.app.Save(record)
+}
+
+func (s *StateStorage) upsertChannel(userID, channelID int64, values map[string]interface{}) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := s.findChannel(userID, channelID)
+	if err != nil {
+		return err
+	}
+	if record == nil {
+		collection, err := s.app.FindCollectionByNameOrId(channelsCollection)
+		if err != nil {
+			return fmt.Errorf("%s collection not found: %w", channelsCollection, err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("userId", strconv.FormatInt(userID, 10))
+		record.Set("channelId", strconv.FormatInt(channelID, 10))
+	}
+
+	for k, v := range values {
+		record.Set(k, v)
+	}
+	return s.app.Save(record)
+}
+
+func (s *StateStorage) findState(userID int64) (*core.Record, error) {
+	record, err := s.app.FindFirstRecordByFilter(stateCollection, "userId = {:userId}", dbx.Params{
+		"userId": strconv.FormatInt(userID, 10),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
+
+func (s *StateStorage) findChannel(userID, channelID int64) (*core.Record, error) {
+	record, err := s.app.FindFirstRecordByFilter(channelsCollection, "userId = {:userId} && channelId = {:channelId}", dbx.Params{
+		"userId":    strconv.FormatInt(userID, 10),
+		"channelId": strconv.FormatInt(channelID, 10),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
+
+// trackChannels registers whitelisted channels that have no stored pts yet, using the pts
+// and access hash from the account's dialogs. Without this, a channel is only tracked after
+// its first live update, and anything it posts during an earlier downtime would be lost.
+func (c *Client) trackChannels(ctx context.Context, userID int64, channelIDs []int64) error {
+	wanted := make(map[int64]bool, len(channelIDs))
+	for _, id := range channelIDs {
+		if _, found, err := c.state.GetChannelPts(ctx, userID, id); err != nil {
+			return err
+		} else if !found {
+			wanted[id] = true
+		}
+	}
+	if len(wanted) == 0 {
+		return nil
+	}
+
+	iter := query.GetDialogs(c.client.API()).BatchSize(100).Iter()
+	for iter.Next(ctx) && len(wanted) > 0 {
+		elem := iter.Value()
+		peer, ok := elem.Peer.(*tg.InputPeerChannel)
+		if !ok || !wanted[peer.ChannelID] {
+			continue
+		}
+		dialog, ok := elem.Dialog.(*tg.Dialog)
+		if !ok {
+			continue
+		}
+		pts, ok := dialog.GetPts()
+		if !ok {
+			continue
+		}
+
+		if err := c.state.SetChannelAccessHash(ctx, userID, peer.ChannelID, peer.AccessHash); err != nil {
+			return err
+		}
+		if err := c.state.SetChannelPts(ctx, userID, peer.ChannelID, pts); err != nil {
+			return err
+		}
+		delete(wanted, peer.ChannelID)
+
+		c.logger.Info("Tracking channel updates", zap.Int64("channelId", peer.ChannelID), zap.Int("pts", pts))
+	}
+
+	return iter.Err()
+}
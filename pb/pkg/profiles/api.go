@@ -0,0 +1,142 @@
+package profiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+var propertyName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Extraction is an extraction result returned by the API.
+type Extraction struct {
+	ID        string                 `json:"id"`
+	Chunk     string                 `json:"chunk"`
+	ChannelID string                 `json:"channelId"`
+	Link      string                 `json:"link"`
+	Data      map[string]interface{} `json:"data"`
+	Created   time.Time              `json:"created"`
+}
+
+// HandleList lists the results of the profile named in the path, newest first.
+//
+// Query parameters: channelId, limit, offset, and any top-level property of the profile
+// schema (e.g. ?city=Berlin). Array properties match results containing the value.
+func (s *Service) HandleList(e *core.RequestEvent) error {
+	name := e.Request.PathValue("profile")
+	profile, err := s.app.FindFirstRecordByFilter(CollectionName, "name = {:name}", dbx.Params{"name": name})
+	if err != nil {
+		return e.NotFoundError("Profile not found", err)
+	}
+
+	var doc schemaDoc
+	if err := json.Unmarshal([]byte(profile.GetString("schema")), &doc); err != nil {
+		return e.InternalServerError("Invalid profile schema", err)
+	}
+
+	params := e.Request.URL.Query()
+	filters := []string{"profile = {:profile}", "chunk.deleted = false", "(chunk.verdict != 'rejected' || chunk.override = true)"}
+	values := dbx.Params{"profile": profile.Id}
+
+	if channelID := params.Get("channelId"); channelID != "" {
+		filters = append(filters, "channelId = {:channelId}")
+		values["channelId"] = channelID
+	}
+
+	// Property names come from the schema and are checked before use in the filter
+	for prop, def := range doc.Properties {
+		value := params.Get(prop)
+		if value == "" || !propertyName.MatchString(prop) {
+			continue
+		}
+
+		var propDef struct {
+			Type interface{} `json:"type"`
+		}
+		_ = json.Unmarshal(def, &propDef)
+
+		key := "p_" + prop
+		op := "="
+		switch propertyType(propDef.Type) {
+		case "integer", "number":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return e.BadRequestError(fmt.Sprintf("Invalid %s", prop), err)
+			}
+			values[key] = n
+		case "boolean":
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return e.BadRequestError(fmt.Sprintf("Invalid %s", prop), err)
+			}
+			values[key] = b
+		case "array":
+			op = "~"
+			values[key] = value
+		default:
+			values[key] = value
+		}
+		filters = append(filters, fmt.Sprintf("data.%s %s {:%s}", prop, op, key))
+	}
+
+	limit, _ := strconv.Atoi(params.Get("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset, _ := strconv.Atoi(params.Get("offset"))
+
+	records, err := s.app.FindRecordsByFilter(ResultsCollectionName, strings.Join(filters, " && "), "-created", limit, offset, values)
+	if err != nil {
+		s.logger.Error("Failed to list extractions", zap.String("profile", name), zap.Error(err))
+		return e.InternalServerError("Failed to list extractions", err)
+	}
+
+	if errs := s.app.ExpandRecords(records, []string{"chunk"}, nil); len(errs) > 0 {
+		s.logger.Warn("Failed to expand extraction chunks", zap.Any("errors", errs))
+	}
+
+	items := make([]Extraction, 0, len(records))
+	for _, record := range records {
+		item := Extraction{
+			ID:        record.Id,
+			Chunk:     record.GetString("chunk"),
+			ChannelID: record.GetString("channelId"),
+			Created:   record.GetDateTime("created").Time(),
+		}
+		if chunk := record.ExpandedOne("chunk"); chunk != nil {
+			item.Link = chunk.GetString("link")
+		}
+		if err := record.UnmarshalJSONField("data", &item.Data); err != nil {
+			s.logger.Warn("Invalid extraction data", zap.String("id", record.Id), zap.Error(err))
+		}
+		items = append(items, item)
+	}
+
+	return e.JSON(200, map[string]interface{}{
+		"profile": name,
+		"items":   items,
+	})
+}
+
+// propertyType returns the JSON schema type of a property; for union types like
+// ["string", "null"] the first non-null type.
+func propertyType(t interface{}) string {
+	switch t := t.(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok && s != "null" {
+				return s
+			}
+		}
+	}
+	return ""
+}
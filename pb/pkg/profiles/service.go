@@ -0,0 +1,209 @@
+package profiles
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"svpb-tmpl/pkg/indexer"
+	"svpb-tmpl/pkg/llm"
+	"svpb-tmpl/pkg/sources"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+const (
+	CollectionName        = "extraction_profiles"
+	ResultsCollectionName = "extractions"
+)
+
+// schemaDoc is the part of a profile's JSON schema the service relies on.
+type schemaDoc struct {
+	Type       string                     `json:"type"`
+	Properties map[string]json.RawMessage `json:"properties"`
+}
+
+// Service runs user-defined extraction profiles: each profile has a JSON schema and a
+// system prompt, and is applied to the chunks of its source channels through the same
+// strict structured-output call as the vacancy analyzer.
+type Service struct {
+	app      core.App
+	analyzer *llm.Analyzer
+	logger   *zap.Logger
+
+	mu        sync.RWMutex
+	byChannel map[int64][]string // channel ID -> IDs of enabled profiles
+}
+
+// NewService creates a new extraction profiles service.
+func NewService(app core.App, analyzer *llm.Analyzer, logger *zap.Logger) *Service {
+	return &Service{
+		app:       app,
+		analyzer:  analyzer,
+		logger:    logger,
+		byChannel: make(map[int64][]string),
+	}
+}
+
+// Load reads the enabled profiles and resolves their sources to channel IDs.
+func (s *Service) Load() error {
+	records, err := s.app.FindAllRecords(CollectionName, dbx.HashExp{"enabled": true})
+	if err != nil {
+		return fmt.Errorf("failed to load extraction profiles: %w", err)
+	}
+
+	byChannel := make(map[int64][]string)
+	for _, record := range records {
+		sourceIDs := record.GetStringSlice("sources")
+		if len(sourceIDs) == 0 {
+			continue
+		}
+		srcs, err := s.app.FindRecordsByIds(sources.CollectionName, sourceIDs)
+		if err != nil {
+			return fmt.Errorf("failed to load sources of profile %s: %w", record.GetString("name"), err)
+		}
+		for _, src := range srcs {
+			if id, err := strconv.ParseInt(src.GetString("peerId"), 10, 64); err == nil {
+				byChannel[id] = append(byChannel[id], record.Id)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	s.byChannel = byChannel
+	s.mu.Unlock()
+
+	return nil
+}
+
+// BindHooks validates profile schemas, reloads the profiles whenever a profile or a
+// source (whose peer ID may have been resolved) changes, and drops the results of
+// chunks deleted (tombstone mode) or rejected by the spam filter.
+func (s *Service) BindHooks() {
+	s.app.OnRecordValidate(CollectionName).BindFunc(func(e *core.RecordEvent) error {
+		if _, err := parseSchema(e.Record); err != nil {
+			return err
+		}
+		return e.Next()
+	})
+
+	onChange := func(e *core.RecordEvent) error {
+		if err := s.Load(); err != nil {
+			s.logger.Error("Failed to reload extraction profiles", zap.Error(err))
+		}
+		return e.Next()
+	}
+	for _, collection := range []string{CollectionName, sources.CollectionName} {
+		s.app.OnRecordAfterCreateSuccess(collection).BindFunc(onChange)
+		s.app.OnRecordAfterUpdateSuccess(collection).BindFunc(onChange)
+		s.app.OnRecordAfterDeleteSuccess(collection).BindFunc(onChange)
+	}
+
+	s.app.OnRecordAfterUpdateSuccess("chunks").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetBool("deleted") || e.Record.GetString("verdict") == "rejected" && !e.Record.GetBool("override") {
+			results, err := s.app.FindAllRecords(ResultsCollectionName, dbx.HashExp{"chunk": e.Record.Id})
+			if err != nil {
+				s.logger.Error("Failed to find extractions", zap.String("chunk", e.Record.Id), zap.Error(err))
+			}
+			for _, result := range results {
+				if err := s.app.Delete(result); err != nil {
+					s.logger.Error("Failed to delete extraction", zap.String("id", result.Id), zap.Error(err))
+				}
+			}
+		}
+		return e.Next()
+	})
+}
+
+// ProfilesFor returns the IDs of the enabled profiles that apply to the given channel.
+func (s *Service) ProfilesFor(channelID int64) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.byChannel[channelID]
+}
+
+// Extract runs a profile on a chunk and saves the structured result, replacing any
+// previous result for the same chunk.
+func (s *Service) Extract(ctx context.Context, profileID, chunkID string) error {
+	profile, err := s.app.FindRecordById(CollectionName, profileID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find profile: %w", err)
+	}
+	chunk, err := s.app.FindRecordById("chunks", chunkID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find chunk: %w", err)
+	}
+
+	schema, err := parseSchema(profile)
+	if err != nil {
+		return err
+	}
+
+	text, err := indexer.MessageText(s.app, chunk)
+	if err != nil {
+		return err
+	}
+
+	var data map[string]interface{}
+	name := profile.GetString("name")
+	if err := s.analyzer.Extract(ctx, name, profile.GetString("prompt"), json.RawMessage(schema), text, &data); err != nil {
+		return fmt.Errorf("failed to run profile %s on chunk %s: %w", name, chunkID, err)
+	}
+
+	result, err := s.app.FindFirstRecordByFilter(ResultsCollectionName, "profile = {:profile} && chunk = {:chunk}", dbx.Params{
+		"profile": profileID,
+		"chunk":   chunkID,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		collection, err := s.app.FindCollectionByNameOrId(ResultsCollectionName)
+		if err != nil {
+			return fmt.Errorf("extractions collection not found: %w", err)
+		}
+		result = core.NewRecord(collection)
+		result.Set("profile", profileID)
+		result.Set("chunk", chunkID)
+		result.Set("channelId", chunk.GetString("channelId"))
+	} else if err != nil {
+		return fmt.Errorf("failed to look up extraction: %w", err)
+	}
+
+	result.Set("data", data)
+	if err := s.app.Save(result); err != nil {
+		return fmt.Errorf("failed to save extraction: %w", err)
+	}
+
+	s.logger.Info("Extraction saved",
+		zap.String("id", result.Id),
+		zap.String("profile", name),
+		zap.String("chunk", chunkID),
+	)
+
+	return nil
+}
+
+// parseSchema returns the raw JSON schema of a profile, checking that it describes an object.
+func parseSchema(profile *core.Record) ([]byte, error) {
+	raw := []byte(profile.GetString("schema"))
+
+	var doc schemaDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil, fmt.Errorf("invalid schema of profile %s: %w", profile.GetString("name"), err)
+	}
+	if doc.Type != "object" || len(doc.Properties) == 0 {
+		return nil, fmt.Errorf("schema of profile %s must be an object with properties", profile.GetString("name"))
+	}
+
+	return raw, nil
+}
@@ -37,9 +37,10 @@ type ChatResponse struct {
 
 // Source represents a citation source.
 type Source struct {
-	ID      string `json:"id"`
-	Link    string `json:"link"`
-	Snippet string `json:"snippet"`
+	ID      string   `json:"id"`
+	Link    string   `json:"link"`
+	Snippet string   `json:"snippet"`
+	Links   []string `json:"links,omitempty"` // All reposts of the source, when it is a duplicate group
 }
 
 // Service handles RAG-based chat functionality.
@@ -362,6 +363,9 @@ func (s *Service) buildContext(docs []indexer.ChunkDocument) (string, []Source)
 		return "", nil
 	}
 
+	// Long posts are split into parts: merge the parts of each post and add their neighbours
+	docs = s.indexer.ExpandNeighbours(docs)
+
 	var contextParts []string
 	sources := make([]Source, 0, len(docs))
 
@@ -379,6 +383,7 @@ func (s *Service) buildContext(docs []indexer.ChunkDocument) (string, []Source)
 			ID:      doc.ID,
 			Link:    doc.Link,
 			Snippet: snippet,
+			Links:   doc.Links,
 		})
 	}
 
@@ -0,0 +1,200 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+const CollectionName = "sources"
+
+// Resolver resolves a public @username to a peer ID and title, joining the chat if needed.
+type Resolver func(ctx context.Context, username string) (peerID int64, title string, err error)
+
+// Registry keeps the enabled sources in memory and in sync with the sources collection,
+// so additions and removals made in the admin UI take effect without a restart.
+type Registry struct {
+	app    core.App
+	logger *zap.Logger
+
+	mu      sync.RWMutex
+	enabled map[int64]bool
+	jobs    map[int64]bool // enabled sources whose posts are analyzed as vacancies
+
+	pending  chan string // IDs of source records waiting for username resolution
+	onChange []func(chatIDs []int64)
+}
+
+// NewRegistry creates a new sources registry.
+func NewRegistry(app core.App, logger *zap.Logger) *Registry {
+	return &Registry{
+		app:     app,
+		logger:  logger,
+		enabled: make(map[int64]bool),
+		jobs:    make(map[int64]bool),
+		pending: make(chan string, 100),
+	}
+}
+
+// Load reads the enabled sources from PocketBase.
+func (r *Registry) Load() error {
+	records, err := r.app.FindAllRecords(CollectionName, dbx.HashExp{"enabled": true})
+	if err != nil {
+		return fmt.Errorf("failed to load sources: %w", err)
+	}
+
+	enabled := make(map[int64]bool, len(records))
+	jobs := make(map[int64]bool)
+	for _, record := range records {
+		if id, err := strconv.ParseInt(record.GetString("peerId"), 10, 64); err == nil {
+			enabled[id] = true
+			if record.GetBool("jobs") {
+				jobs[id] = true
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.enabled = enabled
+	r.jobs = jobs
+	r.mu.Unlock()
+
+	return nil
+}
+
+// BindHooks reloads the registry whenever a source is created, updated or deleted
+// and queues sources added by @username for resolution.
+func (r *Registry) BindHooks() {
+	// A changed username invalidates the previously resolved peer
+	r.app.OnRecordUpdate(CollectionName).BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("username") != e.Record.Original().GetString("username") {
+			e.Record.Set("peerId", "")
+			e.Record.Set("title", "")
+			e.Record.Set("error", "")
+		}
+		return e.Next()
+	})
+
+	onChange := func(e *core.RecordEvent) error {
+		if err := r.Load(); err != nil {
+			r.logger.Error("Failed to reload sources", zap.Error(err))
+		} else {
+			r.mu.RLock()
+			callbacks := r.onChange
+			r.mu.RUnlock()
+			for _, f := range callbacks {
+				f(r.ChatIDs())
+			}
+		}
+		if needsResolve(e.Record) {
+			r.enqueue(e.Record.Id)
+		}
+		return e.Next()
+	}
+	r.app.OnRecordAfterCreateSuccess(CollectionName).BindFunc(onChange)
+	r.app.OnRecordAfterUpdateSuccess(CollectionName).BindFunc(onChange)
+	r.app.OnRecordAfterDeleteSuccess(CollectionName).BindFunc(onChange)
+}
+
+// OnChange registers a callback run with the IDs of the enabled sources whenever they
+// are reloaded after a change.
+func (r *Registry) OnChange(f func(chatIDs []int64)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.onChange = append(r.onChange, f)
+}
+
+// IsAllowed reports whether messages from the given chat should be indexed.
+func (r *Registry) IsAllowed(chatID int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.enabled[chatID]
+}
+
+// IsJobSource reports whether posts from the given chat should be analyzed as vacancies.
+func (r *Registry) IsJobSource(chatID int64) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.jobs[chatID]
+}
+
+// ChatIDs returns the IDs of all enabled sources.
+func (r *Registry) ChatIDs() []int64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ids := make([]int64, 0, len(r.enabled))
+	for id := range r.enabled {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Resolve resolves pending @username sources with resolve until ctx is cancelled.
+// Sources added while no Telegram client was running are picked up on start.
+func (r *Registry) Resolve(ctx context.Context, resolve Resolver) {
+	records, err := r.app.FindAllRecords(CollectionName,
+		dbx.HashExp{"enabled": true, "peerId": "", "error": ""},
+		dbx.Not(dbx.HashExp{"username": ""}),
+	)
+	if err != nil {
+		r.logger.Error("Failed to load unresolved sources", zap.Error(err))
+	}
+	for _, record := range records {
+		r.resolve(ctx, record, resolve)
+	}
+
+	for {
+		select {
+		case id := <-r.pending:
+			record, err := r.app.FindRecordById(CollectionName, id)
+			if err != nil || !needsResolve(record) {
+				continue
+			}
+			r.resolve(ctx, record, resolve)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (r *Registry) resolve(ctx context.Context, record *core.Record, resolve Resolver) {
+	username := strings.TrimPrefix(strings.TrimSpace(record.GetString("username")), "@")
+
+	peerID, title, err := resolve(ctx, username)
+	if err != nil {
+		r.logger.Warn("Failed to resolve source", zap.String("username", username), zap.Error(err))
+		record.Set("error", err.Error())
+	} else {
+		r.logger.Info("Source resolved", zap.String("username", username), zap.Int64("peerId", peerID))
+		record.Set("peerId", strconv.FormatInt(peerID, 10))
+		record.Set("title", title)
+	}
+
+	if err := r.app.Save(record); err != nil {
+		r.logger.Error("Failed to save resolved source", zap.String("username", username), zap.Error(err))
+	}
+}
+
+func (r *Registry) enqueue(id string) {
+	select {
+	case r.pending <- id:
+	default:
+		// Unresolved sources are also picked up the next time Resolve starts
+		r.logger.Warn("Source resolution queue is full", zap.String("id", id))
+	}
+}
+
+// needsResolve reports whether the source was added by @username and has not been resolved yet.
+func needsResolve(record *core.Record) bool {
+	return record.GetBool("enabled") &&
+		record.GetString("peerId") == "" &&
+		record.GetString("username") != "" &&
+		record.GetString("error") == ""
+}
@@ -0,0 +1,299 @@
+package vacancies
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/meilisearch/meilisearch-go"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"go.uber.org/zap"
+)
+
+// reconcileGrace is how long a vacancy may wait for its document before the reconciler
+// repairs it, so vacancies being indexed are left alone.
+const reconcileGrace = 2 * time.Minute
+
+// pageSize is the number of documents read or written at a time.
+const pageSize = 1000
+
+// ReconcileReport lists the differences between the vacancies collection and the vacancies
+// index found by a reconciliation, by vacancy ID. They are repaired unless it was a dry run.
+type ReconcileReport struct {
+	Vacancies int      `json:"vacancies"` // Vacancies in PocketBase
+	Documents int      `json:"documents"` // Documents in the index
+	Missing   []string `json:"missing"`   // Vacancies without a document: indexed
+	Stale     []string `json:"stale"`     // Documents older than their vacancy or chunk: rewritten
+	Orphaned  []string `json:"orphaned"`  // Documents without a vacancy: deleted
+	DryRun    bool     `json:"dryRun"`
+}
+
+// Differences is the number of differences found.
+func (r ReconcileReport) Differences() int {
+	return len(r.Missing) + len(r.Stale) + len(r.Orphaned)
+}
+
+// Reconcile diffs the vacancies and the update times of their chunks against the documents
+// of the vacancies index and repairs the differences, like the chunks reconciler: index
+// writes are not retried, so a failed one is caught here. With dryRun nothing is changed.
+func (s *Service) Reconcile(ctx context.Context, dryRun bool) (ReconcileReport, error) {
+	report := ReconcileReport{DryRun: dryRun}
+	if !s.Searchable() {
+		return report, nil
+	}
+	if !s.reconcileMu.TryLock() {
+		return report, fmt.Errorf("a reconciliation is already running")
+	}
+	defer s.reconcileMu.Unlock()
+
+	start := time.Now()
+
+	// Documents are listed first, as for chunks
+	documents, err := s.documentsUpdated(ctx)
+	if err != nil {
+		return report, err
+	}
+	report.Documents = len(documents)
+
+	var vacancies []struct {
+		ID           string         `db:"id"`
+		Updated      types.DateTime `db:"updated"`
+		ChunkUpdated types.DateTime `db:"chunkUpdated"`
+	}
+	err = s.app.DB().
+		Select("v.id", "v.updated", "c.updated AS chunkUpdated").
+		From(CollectionName+" v").
+		InnerJoin("chunks c", dbx.NewExp("[[c.id]] = [[v.chunk]]")).
+		All(&vacancies)
+	if err != nil {
+		return report, fmt.Errorf("failed to load vacancies: %w", err)
+	}
+	report.Vacancies = len(vacancies)
+
+	cutoff := time.Now().Add(-reconcileGrace)
+	exists := make(map[string]bool, len(vacancies))
+	for _, vacancy := range vacancies {
+		exists[vacancy.ID] = true
+
+		updated := vacancy.Updated.Time()
+		if chunkUpdated := vacancy.ChunkUpdated.Time(); chunkUpdated.After(updated) {
+			updated = chunkUpdated
+		}
+		if updated.After(cutoff) {
+			continue
+		}
+
+		indexed, ok := documents[vacancy.ID]
+		switch {
+		case !ok:
+			report.Missing = append(report.Missing, vacancy.ID)
+		case updated.After(indexed):
+			report.Stale = append(report.Stale, vacancy.ID)
+		}
+	}
+	for id := range documents {
+		if !exists[id] {
+			report.Orphaned = append(report.Orphaned, id)
+		}
+	}
+
+	if !dryRun {
+		if err := s.writeDocuments(ctx, IndexName, append(report.Missing, report.Stale...)); err != nil {
+			return report, err
+		}
+		for i := 0; i < len(report.Orphaned); i += pageSize {
+			task, err := s.meili.Index(IndexName).DeleteDocuments(report.Orphaned[i:min(i+pageSize, len(report.Orphaned))], nil)
+			if err == nil {
+				err = s.waitForTask(task.TaskUID)
+			}
+			if err != nil {
+				return report, fmt.Errorf("failed to delete vacancies: %w", err)
+			}
+		}
+	}
+
+	s.logger.Info("Vacancies index reconciled",
+		zap.Int("vacancies", report.Vacancies),
+		zap.Int("documents", report.Documents),
+		zap.Int("missing", len(report.Missing)),
+		zap.Int("stale", len(report.Stale)),
+		zap.Int("orphaned", len(report.Orphaned)),
+		zap.Bool("dryRun", dryRun),
+		zap.Duration("took", time.Since(start)),
+	)
+	return report, nil
+}
+
+// ScheduleReconcile runs the reconciler with the app cron on the given schedule.
+func (s *Service) ScheduleReconcile(schedule string) error {
+	return s.app.Cron().Add("reconcileVacancies", schedule, func() {
+		report, err := s.Reconcile(context.Background(), false)
+		if err != nil {
+			s.logger.Error("Vacancies index reconciliation failed", zap.Error(err))
+			return
+		}
+		if report.Differences() > 0 {
+			s.logger.Warn("Vacancies index was out of sync with PocketBase",
+				zap.Strings("missing", report.Missing),
+				zap.Strings("stale", report.Stale),
+				zap.Strings("orphaned", report.Orphaned),
+			)
+		}
+	})
+}
+
+// Reindex rebuilds the vacancies index from PocketBase: it writes every vacancy to a fresh
+// index, swaps it in place of the current one and writes again the vacancies changed
+// meanwhile, which went to the old index. Returns the number of vacancies indexed.
+func (s *Service) Reindex(ctx context.Context) (int, error) {
+	if !s.Searchable() {
+		return 0, nil
+	}
+
+	start := types.NowDateTime()
+	staging := fmt.Sprintf("%s_%d", IndexName, time.Now().Unix())
+
+	fail := func(err error) (int, error) {
+		if _, delErr := s.meili.DeleteIndex(staging); delErr != nil {
+			s.logger.Warn("Failed to delete the unfinished index", zap.String("index", staging), zap.Error(delErr))
+		}
+		return 0, err
+	}
+
+	// The swap needs both indexes
+	if err := s.ensureIndex(IndexName); err != nil {
+		return fail(err)
+	}
+	if err := s.ensureIndex(staging); err != nil {
+		return fail(err)
+	}
+
+	var ids []string
+	if err := s.app.RecordQuery(CollectionName).Select("id").OrderBy("id").Column(&ids); err != nil {
+		return fail(fmt.Errorf("failed to load vacancies: %w", err))
+	}
+	if err := s.writeDocuments(ctx, staging, ids); err != nil {
+		return fail(err)
+	}
+
+	task, err := s.meili.SwapIndexes([]*meilisearch.SwapIndexesParams{{Indexes: []string{IndexName, staging}}})
+	if err == nil {
+		err = s.waitForTask(task.TaskUID)
+	}
+	if err != nil {
+		return fail(fmt.Errorf("failed to swap indexes: %w", err))
+	}
+	if _, err := s.meili.DeleteIndex(staging); err != nil {
+		s.logger.Warn("Failed to delete the previous index", zap.String("index", staging), zap.Error(err))
+	}
+
+	var changed []string
+	err = s.app.DB().
+		Select("v.id").
+		From(CollectionName+" v").
+		InnerJoin("chunks c", dbx.NewExp("[[c.id]] = [[v.chunk]]")).
+		Where(dbx.NewExp("[[v.updated]] >= {:start} OR [[c.updated]] >= {:start}", dbx.Params{"start": start.String()})).
+		Column(&changed)
+	if err != nil {
+		return len(ids), fmt.Errorf("failed to load changed vacancies: %w", err)
+	}
+	if err := s.writeDocuments(ctx, IndexName, changed); err != nil {
+		return len(ids), err
+	}
+
+	s.logger.Info("Vacancies index rebuilt", zap.Int("vacancies", len(ids)), zap.Int("caughtUp", len(changed)))
+	return len(ids), nil
+}
+
+// documentsUpdated lists the updated times of the documents of the vacancies index.
+func (s *Service) documentsUpdated(ctx context.Context) (map[string]time.Time, error) {
+	index := s.meili.Index(IndexName)
+	documents := make(map[string]time.Time)
+
+	for offset := int64(0); ; offset += pageSize {
+		var res meilisearch.DocumentsResult
+		err := index.GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+			Offset: offset,
+			Limit:  pageSize,
+			Fields: []string{"id", "updated"},
+		}, &res)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list vacancies: %w", err)
+		}
+
+		for _, hit := range res.Results {
+			var doc struct {
+				ID      string    `json:"id"`
+				Updated time.Time `json:"updated"`
+			}
+			if err := hit.DecodeInto(&doc); err != nil {
+				continue
+			}
+			documents[doc.ID] = doc.Updated
+		}
+
+		if len(res.Results) < pageSize {
+			return documents, nil
+		}
+	}
+}
+
+// writeDocuments writes the documents of the given vacancies to an index, a page at a time.
+func (s *Service) writeDocuments(ctx context.Context, uid string, ids []string) error {
+	primaryKey := "id"
+	for i := 0; i < len(ids); i += pageSize {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		records, err := s.app.FindRecordsByIds(CollectionName, ids[i:min(i+pageSize, len(ids))])
+		if err != nil {
+			return fmt.Errorf("failed to load vacancies: %w", err)
+		}
+		chunkIDs := make([]string, len(records))
+		for j, record := range records {
+			chunkIDs[j] = record.GetString("chunk")
+		}
+		chunks, err := s.app.FindRecordsByIds("chunks", chunkIDs)
+		if err != nil {
+			return fmt.Errorf("failed to load vacancy chunks: %w", err)
+		}
+		byID := make(map[string]*core.Record, len(chunks))
+		for _, chunk := range chunks {
+			byID[chunk.Id] = chunk
+		}
+
+		docs := make([]VacancyDocument, 0, len(records))
+		for _, record := range records {
+			if chunk := byID[record.GetString("chunk")]; chunk != nil {
+				docs = append(docs, s.vacancyDocument(record, chunk))
+			}
+		}
+		if len(docs) == 0 {
+			continue
+		}
+
+		task, err := s.meili.Index(uid).AddDocuments(docs, &meilisearch.DocumentOptions{PrimaryKey: &primaryKey})
+		if err == nil {
+			err = s.waitForTask(task.TaskUID)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to index vacancies: %w", err)
+		}
+	}
+	return nil
+}
+
+// waitForTask blocks until a MeiliSearch task is finished and returns an error if it failed.
+func (s *Service) waitForTask(taskUID int64) error {
+	task, err := s.meili.WaitForTask(taskUID, time.Second)
+	if err != nil {
+		return err
+	}
+	if task.Status == meilisearch.TaskStatusFailed {
+		return fmt.Errorf("meilisearch task failed: %s (code: %s, type: %s)", task.Error.Message, task.Error.Code, task.Error.Type)
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package vacancies
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"svpb-tmpl/pkg/indexer"
+
+	"github.com/meilisearch/meilisearch-go"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+const IndexName = "vacancies"
+
+// Facets are the attributes facet counts are returned for.
+var Facets = []string{"skills", "grade", "remote", "currency"}
+
+// VacancyDocument represents a vacancy in the MeiliSearch index.
+type VacancyDocument struct {
+	ID          string    `json:"id"`
+	Chunk       string    `json:"chunk"`
+	ChannelID   string    `json:"channelId"`
+	Title       string    `json:"title"`
+	Company     string    `json:"company"`
+	SalaryMin   int       `json:"salaryMin"` // Equal to SalaryMax when only the upper bound is known
+	SalaryMax   int       `json:"salaryMax"` // Equal to SalaryMin when only the lower bound is known
+	Currency    string    `json:"currency"`
+	Skills      []string  `json:"skills"`
+	Grade       string    `json:"grade"`
+	Remote      bool      `json:"remote"`
+	Location    string    `json:"location"`
+	Description string    `json:"description"`
+	Link        string    `json:"link"`   // Telegram link of the original post
+	Posted      time.Time `json:"posted"` // Publication date of the original post
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"` // Last change of the vacancy or its chunk
+}
+
+// SearchResponse is the response of the vacancy search API.
+type SearchResponse struct {
+	Hits   []VacancyDocument           `json:"hits"`
+	Total  int64                       `json:"total"`
+	Facets map[string]map[string]int64 `json:"facets"`
+}
+
+// EnsureIndex creates or updates the MeiliSearch index with proper settings.
+func (s *Service) EnsureIndex(ctx context.Context) error {
+	if !s.Searchable() {
+		return nil
+	}
+	return s.ensureIndex(IndexName)
+}
+
+// ensureIndex creates the index with the given UID if it doesn't exist and applies the
+// vacancies settings.
+func (s *Service) ensureIndex(uid string) error {
+	// Create index if it doesn't exist
+	_, err := s.meili.CreateIndex(&meilisearch.IndexConfig{
+		Uid:        uid,
+		PrimaryKey: "id",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create index: %w", err)
+	}
+
+	index := s.meili.Index(uid)
+
+	// Configure searchable attributes
+	searchableAttrs := []string{"title", "company", "skills", "description", "location"}
+	_, err = index.UpdateSearchableAttributes(&searchableAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to update searchable attributes: %w", err)
+	}
+
+	// Configure filterable attributes
+	filterableAttrs := []interface{}{"skills", "grade", "remote", "currency", "salaryMin", "salaryMax", "channelId", "posted", "created", "updated"}
+	_, err = index.UpdateFilterableAttributes(&filterableAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to update filterable attributes: %w", err)
+	}
+
+	// Configure sortable attributes
+	sortableAttrs := []string{"posted", "created", "salaryMin", "salaryMax"}
+	_, err = index.UpdateSortableAttributes(&sortableAttrs)
+	if err != nil {
+		return fmt.Errorf("failed to update sortable attributes: %w", err)
+	}
+
+	s.logger.Info("MeiliSearch index configured", zap.String("index", uid))
+	return nil
+}
+
+// BindHooks keeps the vacancies index in sync with the vacancies collection and drops
+// vacancies whose chunk was deleted (tombstone mode) or rejected by the spam filter.
+func (s *Service) BindHooks() {
+	if s.Searchable() {
+		onSave := func(e *core.RecordEvent) error {
+			s.indexVacancy(e.Record)
+			return e.Next()
+		}
+		s.app.OnRecordAfterCreateSuccess(CollectionName).BindFunc(onSave)
+		s.app.OnRecordAfterUpdateSuccess(CollectionName).BindFunc(onSave)
+
+		s.app.OnRecordAfterDeleteSuccess(CollectionName).BindFunc(func(e *core.RecordEvent) error {
+			if _, err := s.meili.Index(IndexName).DeleteDocument(e.Record.Id, nil); err != nil {
+				s.logger.Error("Failed to delete vacancy from MeiliSearch", zap.String("id", e.Record.Id), zap.Error(err))
+			}
+			return e.Next()
+		})
+	}
+
+	// Chunk changes: links rewritten by tg-relink, deletions and spam verdicts
+	s.app.OnRecordAfterUpdateSuccess("chunks").BindFunc(func(e *core.RecordEvent) error {
+		vacancy, err := s.findByChunk(e.Record.Id)
+		if err != nil || vacancy == nil {
+			return e.Next()
+		}
+
+		if e.Record.GetBool("deleted") || e.Record.GetString("verdict") == "rejected" && !e.Record.GetBool("override") {
+			if err := s.app.Delete(vacancy); err != nil {
+				s.logger.Error("Failed to delete vacancy", zap.String("id", vacancy.Id), zap.Error(err))
+			}
+		} else if s.Searchable() {
+			s.indexVacancy(vacancy)
+		}
+		return e.Next()
+	})
+}
+
+// indexVacancy adds or replaces the MeiliSearch document of a vacancy. Failed writes are
+// repaired by the reconciler.
+func (s *Service) indexVacancy(record *core.Record) {
+	chunk, err := s.app.FindRecordById("chunks", record.GetString("chunk"))
+	if err != nil {
+		s.logger.Error("Failed to find vacancy chunk", zap.String("id", record.Id), zap.Error(err))
+		return
+	}
+
+	primaryKey := "id"
+	if _, err := s.meili.Index(IndexName).AddDocuments([]VacancyDocument{s.vacancyDocument(record, chunk)}, &meilisearch.DocumentOptions{
+		PrimaryKey: &primaryKey,
+	}); err != nil {
+		s.logger.Error("Failed to index vacancy", zap.String("id", record.Id), zap.Error(err))
+	}
+}
+
+// vacancyDocument builds the MeiliSearch document of a vacancy extracted from chunk.
+func (s *Service) vacancyDocument(record, chunk *core.Record) VacancyDocument {
+	var skills []string
+	if err := record.UnmarshalJSONField("skills", &skills); err != nil {
+		s.logger.Warn("Invalid vacancy skills", zap.String("id", record.Id), zap.Error(err))
+	}
+
+	doc := VacancyDocument{
+		ID:          record.Id,
+		Chunk:       chunk.Id,
+		ChannelID:   record.GetString("channelId"),
+		Title:       record.GetString("title"),
+		Company:     record.GetString("company"),
+		SalaryMin:   record.GetInt("salaryMin"),
+		SalaryMax:   record.GetInt("salaryMax"),
+		Currency:    strings.ToUpper(record.GetString("currency")),
+		Skills:      skills,
+		Grade:       record.GetString("grade"),
+		Remote:      record.GetBool("remote"),
+		Location:    record.GetString("location"),
+		Description: record.GetString("description"),
+		Link:        chunk.GetString("link"),
+		Posted:      record.GetDateTime("posted").Time(),
+		Created:     chunk.GetDateTime("created").Time(),
+		Updated:     record.GetDateTime("updated").Time(),
+	}
+	if updated := chunk.GetDateTime("updated").Time(); updated.After(doc.Updated) {
+		doc.Updated = updated
+	}
+	if doc.Posted.IsZero() {
+		// Extracted before the post date was stored
+		doc.Posted = indexer.PostDate(chunk)
+	}
+	// One-sided ranges ("from 3000") still match salary filters and sorts
+	if doc.SalaryMin == 0 {
+		doc.SalaryMin = doc.SalaryMax
+	}
+	if doc.SalaryMax == 0 {
+		doc.SalaryMax = doc.SalaryMin
+	}
+	return doc
+}
+
+// HandleSearch searches vacancies.
+//
+// Query parameters: q (full text), skills (comma-separated, any of), grade, remote (true/false),
+// currency, salaryMin and salaryMax (the vacancy range must overlap them), sort (date or salary),
+// limit and offset.
+func (s *Service) HandleSearch(e *core.RequestEvent) error {
+	params := e.Request.URL.Query()
+
+	var filters []string
+	if skills := splitList(params.Get("skills")); len(skills) > 0 {
+		quoted := make([]string, len(skills))
+		for i, skill := range skills {
+			quoted[i] = strconv.Quote(skill)
+		}
+		filters = append(filters, fmt.Sprintf("skills IN [%s]", strings.Join(quoted, ", ")))
+	}
+	if grade := params.Get("grade"); grade != "" {
+		filters = append(filters, "grade = "+strconv.Quote(grade))
+	}
+	if remote := params.Get("remote"); remote != "" {
+		isRemote, err := strconv.ParseBool(remote)
+		if err != nil {
+			return e.BadRequestError("Invalid remote", err)
+		}
+		filters = append(filters, fmt.Sprintf("remote = %t", isRemote))
+	}
+	if currency := params.Get("currency"); currency != "" {
+		filters = append(filters, "currency = "+strconv.Quote(strings.ToUpper(currency)))
+	}
+	if v := params.Get("salaryMin"); v != "" {
+		salary, err := strconv.Atoi(v)
+		if err != nil {
+			return e.BadRequestError("Invalid salaryMin", err)
+		}
+		filters = append(filters, fmt.Sprintf("salaryMax >= %d", salary))
+	}
+	if v := params.Get("salaryMax"); v != "" {
+		salary, err := strconv.Atoi(v)
+		if err != nil {
+			return e.BadRequestError("Invalid salaryMax", err)
+		}
+		filters = append(filters, fmt.Sprintf("salaryMin <= %d", salary), "salaryMin > 0")
+	}
+
+	var sort []string
+	switch params.Get("sort") {
+	case "", "date":
+		sort = []string{"posted:desc"}
+	case "salary":
+		sort = []string{"salaryMax:desc", "posted:desc"}
+	default:
+		return e.BadRequestError("Invalid sort, expected date or salary", nil)
+	}
+
+	limit, _ := strconv.ParseInt(params.Get("limit"), 10, 64)
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	offset, _ := strconv.ParseInt(params.Get("offset"), 10, 64)
+
+	req := &meilisearch.SearchRequest{
+		Limit:  limit,
+		Offset: offset,
+		Facets: Facets,
+		Sort:   sort,
+	}
+	if len(filters) > 0 {
+		req.Filter = filters
+	}
+
+	res, err := s.meili.Index(IndexName).Search(params.Get("q"), req)
+	if err != nil {
+		s.logger.Error("Failed to search vacancies", zap.Error(err))
+		return e.InternalServerError("Search failed", err)
+	}
+
+	resp := SearchResponse{
+		Hits:   make([]VacancyDocument, 0, len(res.Hits)),
+		Total:  res.EstimatedTotalHits,
+		Facets: map[string]map[string]int64{},
+	}
+	for _, hit := range res.Hits {
+		var doc VacancyDocument
+		if err := hit.DecodeInto(&doc); err != nil {
+			s.logger.Warn("Failed to decode hit", zap.Error(err))
+			continue
+		}
+		resp.Hits = append(resp.Hits, doc)
+	}
+	if len(res.FacetDistribution) > 0 {
+		if err := json.Unmarshal(res.FacetDistribution, &resp.Facets); err != nil {
+			s.logger.Warn("Failed to decode facets", zap.Error(err))
+		}
+	}
+
+	return e.JSON(200, resp)
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
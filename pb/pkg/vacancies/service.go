@@ -0,0 +1,168 @@
+package vacancies
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"svpb-tmpl/pkg/config"
+	"svpb-tmpl/pkg/indexer"
+	"svpb-tmpl/pkg/llm"
+
+	"github.com/meilisearch/meilisearch-go"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"go.uber.org/zap"
+)
+
+const CollectionName = "vacancies"
+
+// Chunk vacancy statuses.
+const (
+	StatusVacancy    = "vacancy"
+	StatusNotVacancy = "notVacancy"
+)
+
+// Service extracts structured vacancies from the chunks of job sources and serves vacancy search.
+type Service struct {
+	app      core.App
+	meili    meilisearch.ServiceManager // nil without MeiliSearch (sqlite retriever): no vacancy search
+	analyzer *llm.Analyzer
+	logger   *zap.Logger
+
+	onVacancy []func(ctx context.Context, chunk *core.Record)
+
+	reconcileMu sync.Mutex
+}
+
+// NewService creates a new vacancies service. With the sqlite retriever, which needs no
+// MeiliSearch, vacancies are extracted but not searchable.
+func NewService(app core.App, cfg *config.Config, analyzer *llm.Analyzer, logger *zap.Logger) *Service {
+	s := &Service{
+		app:      app,
+		analyzer: analyzer,
+		logger:   logger,
+	}
+	if cfg.Retriever != indexer.RetrieverSQLite {
+		s.meili = meilisearch.New(cfg.MeiliHost, meilisearch.WithAPIKey(cfg.MeiliMasterKey))
+	}
+	return s
+}
+
+// Searchable tells whether vacancies are indexed for HandleSearch.
+func (s *Service) Searchable() bool {
+	return s.meili != nil
+}
+
+// OnVacancy registers a callback run after a vacancy is extracted from a chunk.
+func (s *Service) OnVacancy(f func(ctx context.Context, chunk *core.Record)) {
+	s.onVacancy = append(s.onVacancy, f)
+}
+
+// Analyze runs the LLM analyzer on a chunk and stores the result as its vacancy.
+// Chunks that are not vacancies are marked as such and lose any previous vacancy
+// (e.g. after an edit).
+func (s *Service) Analyze(ctx context.Context, chunkID string) error {
+	chunk, err := s.app.FindRecordById("chunks", chunkID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Deleted before it was analyzed
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find chunk: %w", err)
+	}
+
+	text, err := indexer.MessageText(s.app, chunk)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.analyzer.AnalyzeVacancy(ctx, text)
+	if err != nil {
+		return fmt.Errorf("failed to analyze chunk %s: %w", chunkID, err)
+	}
+
+	vacancy, err := s.findByChunk(chunkID)
+	if err != nil {
+		return fmt.Errorf("failed to look up vacancy: %w", err)
+	}
+
+	if !data.IsVacancy {
+		if vacancy != nil {
+			if err := s.app.Delete(vacancy); err != nil {
+				return fmt.Errorf("failed to delete vacancy %s: %w", vacancy.Id, err)
+			}
+		}
+		return s.setStatus(chunk, StatusNotVacancy)
+	}
+
+	if vacancy == nil {
+		collection, err := s.app.FindCollectionByNameOrId(CollectionName)
+		if err != nil {
+			return fmt.Errorf("vacancies collection not found: %w", err)
+		}
+		vacancy = core.NewRecord(collection)
+		vacancy.Set("chunk", chunkID)
+		vacancy.Set("channelId", chunk.GetString("channelId"))
+	}
+
+	vacancy.Set("title", data.Title)
+	vacancy.Set("company", data.Company)
+	vacancy.Set("salaryMin", data.SalaryMin)
+	vacancy.Set("salaryMax", data.SalaryMax)
+	vacancy.Set("currency", data.Currency)
+	vacancy.Set("skills", data.Skills)
+	vacancy.Set("grade", data.Grade)
+	vacancy.Set("remote", data.IsRemote)
+	vacancy.Set("location", data.Location)
+	vacancy.Set("description", data.Description)
+	vacancy.Set("posted", indexer.PostDate(chunk))
+	if err := s.app.Save(vacancy); err != nil {
+		return fmt.Errorf("failed to save vacancy: %w", err)
+	}
+
+	s.logger.Info("Vacancy extracted",
+		zap.String("id", vacancy.Id),
+		zap.String("chunk", chunkID),
+		zap.String("title", data.Title),
+	)
+
+	if err := s.setStatus(chunk, StatusVacancy); err != nil {
+		return err
+	}
+
+	for _, f := range s.onVacancy {
+		f(ctx, chunk)
+	}
+
+	return nil
+}
+
+// setStatus writes the vacancy status of a chunk straight to its column: a regular save would
+// run the chunks hooks (outbox, re-upload, analysis) and bump the updated time reconciliation
+// compares against the index, for a field the index doesn't hold.
+func (s *Service) setStatus(chunk *core.Record, status string) error {
+	if chunk.GetString("vacancyStatus") == status {
+		return nil
+	}
+	_, err := s.app.DB().Update(chunk.Collection().Name,
+		dbx.Params{"vacancyStatus": status},
+		dbx.HashExp{"id": chunk.Id},
+	).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to mark chunk %s: %w", chunk.Id, err)
+	}
+	chunk.Set("vacancyStatus", status)
+	return nil
+}
+
+// findByChunk returns the vacancy extracted from the chunk, or nil if there is none.
+func (s *Service) findByChunk(chunkID string) (*core.Record, error) {
+	record, err := s.app.FindFirstRecordByFilter(CollectionName, "chunk = {:chunk}", dbx.Params{"chunk": chunkID})
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return record, err
+}
@@ -0,0 +1,116 @@
+package watches
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"go.uber.org/zap"
+)
+
+const (
+	AccountsCollectionName = "telegram_accounts"
+
+	// linkCodeTTL is how long a link code can be sent to the Telegram account.
+	linkCodeTTL = 15 * time.Minute
+
+	linkCodeLength   = 8
+	linkCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+)
+
+// ErrNoAccount is returned when delivering an alert to a user without a linked Telegram account.
+var ErrNoAccount = errors.New("no linked Telegram account")
+
+// Account is a Telegram user alerts are delivered to.
+type Account struct {
+	ID         int64
+	AccessHash int64 // of the user, as seen by the service account
+	Username   string
+}
+
+// linkCode is a pending link of a Telegram account to a user.
+type linkCode struct {
+	user    string
+	expires time.Time
+}
+
+// HandleLink starts linking the Telegram account alerts are sent to: it returns a one-time
+// code the authenticated user sends as "/start <code>" to the service's Telegram account,
+// which proves the account is theirs (see Link).
+func (s *Service) HandleLink(e *core.RequestEvent) error {
+	code := security.RandomStringWithAlphabet(linkCodeLength, linkCodeAlphabet)
+	expires := time.Now().Add(linkCodeTTL)
+
+	s.linksMu.Lock()
+	for c, pending := range s.links {
+		if pending.user == e.Auth.Id || time.Now().After(pending.expires) {
+			delete(s.links, c)
+		}
+	}
+	s.links[code] = linkCode{user: e.Auth.Id, expires: expires}
+	s.linksMu.Unlock()
+
+	return e.JSON(200, map[string]any{
+		"code":    code,
+		"command": "/start " + code,
+		"expires": expires.UTC(),
+	})
+}
+
+// Link links the Telegram account that sent a link code to the user the code was issued
+// to, replacing their previous account. Returns false for unknown or expired codes.
+func (s *Service) Link(code string, account Account) (bool, error) {
+	s.linksMu.Lock()
+	pending, ok := s.links[code]
+	delete(s.links, code)
+	s.linksMu.Unlock()
+	if !ok || time.Now().After(pending.expires) {
+		return false, nil
+	}
+
+	record, err := s.app.FindFirstRecordByFilter(AccountsCollectionName, "user = {:user}", dbx.Params{"user": pending.user})
+	if errors.Is(err, sql.ErrNoRows) {
+		collection, err := s.app.FindCollectionByNameOrId(AccountsCollectionName)
+		if err != nil {
+			return false, fmt.Errorf("telegram_accounts collection not found: %w", err)
+		}
+		record = core.NewRecord(collection)
+		record.Set("user", pending.user)
+	} else if err != nil {
+		return false, fmt.Errorf("failed to find Telegram account: %w", err)
+	}
+
+	record.Set("telegramId", strconv.FormatInt(account.ID, 10))
+	record.Set("accessHash", strconv.FormatInt(account.AccessHash, 10))
+	record.Set("username", account.Username)
+	if err := s.app.Save(record); err != nil {
+		return false, fmt.Errorf("failed to save Telegram account: %w", err)
+	}
+
+	s.logger.Info("Telegram account linked", zap.String("user", pending.user), zap.Int64("telegramId", account.ID))
+	return true, nil
+}
+
+// account returns the Telegram account linked by a user.
+func (s *Service) account(user string) (Account, error) {
+	record, err := s.app.FindFirstRecordByFilter(AccountsCollectionName, "user = {:user}", dbx.Params{"user": user})
+	if errors.Is(err, sql.ErrNoRows) {
+		return Account{}, ErrNoAccount
+	}
+	if err != nil {
+		return Account{}, fmt.Errorf("failed to find Telegram account: %w", err)
+	}
+
+	id, err := strconv.ParseInt(record.GetString("telegramId"), 10, 64)
+	if err != nil {
+		return Account{}, fmt.Errorf("invalid Telegram account %s: %w", record.Id, err)
+	}
+	accessHash, _ := strconv.ParseInt(record.GetString("accessHash"), 10, 64)
+
+	return Account{ID: id, AccessHash: accessHash, Username: record.GetString("username")}, nil
+}
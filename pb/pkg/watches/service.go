@@ -0,0 +1,368 @@
+package watches
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"svpb-tmpl/pkg/embedding"
+	"svpb-tmpl/pkg/indexer"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+	"go.uber.org/zap"
+)
+
+const (
+	CollectionName       = "watches"
+	AlertsCollectionName = "watch_alerts"
+
+	// DefaultThreshold is used for watches without a threshold, matching the
+	// ranking score threshold of the RAG search.
+	DefaultThreshold = 0.5
+
+	// semanticRatio weighs the embedding similarity against keyword matching,
+	// as in indexer.SearchHybrid.
+	semanticRatio = 0.6
+
+	snippetLength = 300
+)
+
+// Alert statuses.
+const (
+	StatusSent        = "sent"
+	StatusRateLimited = "rateLimited"
+	StatusFailed      = "failed"
+)
+
+// Embedder generates the embedding of a text.
+type Embedder func(ctx context.Context, text string) ([]float32, error)
+
+// Sender delivers a Telegram message to an account.
+type Sender func(ctx context.Context, to Account, text string) error
+
+type watch struct {
+	id            string
+	user          string
+	query         string
+	terms         []string
+	channelID     string
+	vacanciesOnly bool
+	threshold     float64
+	embedding     []float32
+}
+
+// Service evaluates newly indexed chunks against the active watches (saved searches)
+// and alerts their owners through the Telegram account they linked.
+type Service struct {
+	app       core.App
+	embed     Embedder
+	model     string // embedding model and dimensions of embed, stored with the query embeddings
+	rateLimit int    // max alerts per user per hour
+	logger    *zap.Logger
+
+	mu      sync.RWMutex
+	watches []watch
+	send    Sender
+
+	linksMu sync.Mutex
+	links   map[string]linkCode // pending link codes
+}
+
+// NewService creates a new watches service.
+func NewService(app core.App, embed Embedder, model string, rateLimit int, logger *zap.Logger) *Service {
+	return &Service{
+		app:       app,
+		embed:     embed,
+		model:     model,
+		rateLimit: rateLimit,
+		logger:    logger,
+		links:     make(map[string]linkCode),
+	}
+}
+
+// SetSender sets how alerts are delivered. Until it is set, matches are not evaluated.
+func (s *Service) SetSender(send Sender) {
+	s.mu.Lock()
+	s.send = send
+	s.mu.Unlock()
+}
+
+// Load reads the active watches from PocketBase.
+func (s *Service) Load() error {
+	records, err := s.app.FindAllRecords(CollectionName, dbx.HashExp{"active": true})
+	if err != nil {
+		return fmt.Errorf("failed to load watches: %w", err)
+	}
+
+	watches := make([]watch, 0, len(records))
+	for _, record := range records {
+		w := watch{
+			id:            record.Id,
+			user:          record.GetString("user"),
+			query:         record.GetString("query"),
+			terms:         terms(record.GetString("query")),
+			channelID:     record.GetString("channelId"),
+			vacanciesOnly: record.GetBool("vacanciesOnly"),
+			threshold:     record.GetFloat("threshold"),
+		}
+		if w.threshold <= 0 {
+			w.threshold = DefaultThreshold
+		}
+		if err := record.UnmarshalJSONField("embedding", &w.embedding); err != nil || len(w.embedding) == 0 {
+			s.logger.Warn("Watch has no query embedding, skipping", zap.String("id", record.Id))
+			continue
+		}
+		if record.GetString("embeddingModel") != s.model {
+			s.logger.Warn("Watch query was embedded with another model, skipping", zap.String("id", record.Id))
+			continue
+		}
+		watches = append(watches, w)
+	}
+
+	s.mu.Lock()
+	s.watches = watches
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Reembed embeds again the queries of the watches embedded with another model or
+// dimensions (e.g. after EMBEDDING_MODEL changed), whose similarity to new chunks
+// could not be computed. Called on startup, before Load.
+func (s *Service) Reembed(ctx context.Context) error {
+	records, err := s.app.FindAllRecords(CollectionName, dbx.Not(dbx.HashExp{"embeddingModel": s.model}))
+	if err != nil {
+		return fmt.Errorf("failed to find stale watches: %w", err)
+	}
+
+	for _, record := range records {
+		if err := s.embedQuery(ctx, record); err != nil {
+			return err
+		}
+		// Without hooks, so the watches are loaded once afterwards
+		if err := s.app.UnsafeWithoutHooks().Save(record); err != nil {
+			return fmt.Errorf("failed to save watch %s: %w", record.Id, err)
+		}
+	}
+
+	if len(records) > 0 {
+		s.logger.Info("Watch queries re-embedded", zap.Int("watches", len(records)), zap.String("model", s.model))
+	}
+	return nil
+}
+
+// embedQuery sets the embedding of a watch query.
+func (s *Service) embedQuery(ctx context.Context, record *core.Record) error {
+	embedding, err := s.embed(ctx, record.GetString("query"))
+	if err != nil {
+		return fmt.Errorf("failed to embed watch query: %w", err)
+	}
+	record.Set("embedding", embedding)
+	record.Set("embeddingModel", s.model)
+	return nil
+}
+
+// BindHooks embeds watch queries when they are saved and reloads the watches on changes.
+func (s *Service) BindHooks() {
+	onSave := func(e *core.RecordEvent) error {
+		query := e.Record.GetString("query")
+		if e.Record.IsNew() || query != e.Record.Original().GetString("query") || e.Record.GetString("embeddingModel") != s.model {
+			if err := s.embedQuery(e.Context, e.Record); err != nil {
+				return err
+			}
+		}
+		return e.Next()
+	}
+	s.app.OnRecordCreate(CollectionName).BindFunc(onSave)
+	s.app.OnRecordUpdate(CollectionName).BindFunc(onSave)
+
+	onChange := func(e *core.RecordEvent) error {
+		if err := s.Load(); err != nil {
+			s.logger.Error("Failed to reload watches", zap.Error(err))
+		}
+		return e.Next()
+	}
+	s.app.OnRecordAfterCreateSuccess(CollectionName).BindFunc(onChange)
+	s.app.OnRecordAfterUpdateSuccess(CollectionName).BindFunc(onChange)
+	s.app.OnRecordAfterDeleteSuccess(CollectionName).BindFunc(onChange)
+}
+
+// HandleIndexed evaluates a newly indexed chunk against the watches without a vacancy filter.
+// Every part of a long message is evaluated, but alerted once.
+func (s *Service) HandleIndexed(ctx context.Context, chunk *core.Record, embedding []float32) {
+	s.evaluate(ctx, chunk, chunk.GetString("content"), embedding, false)
+}
+
+// HandleVacancy evaluates a chunk just recognized as a vacancy against the vacancy watches,
+// on the text of its whole message as the analyzer saw it.
+func (s *Service) HandleVacancy(ctx context.Context, chunk *core.Record) {
+	if !s.hasWatches(true) {
+		return
+	}
+	text, err := indexer.MessageText(s.app, chunk)
+	if err != nil {
+		s.logger.Error("Failed to read vacancy for watches", zap.String("chunk", chunk.Id), zap.Error(err))
+		return
+	}
+	embedding, err := s.embed(ctx, text)
+	if err != nil {
+		s.logger.Error("Failed to embed vacancy for watches", zap.String("chunk", chunk.Id), zap.Error(err))
+		return
+	}
+	s.evaluate(ctx, chunk, text, embedding, true)
+}
+
+func (s *Service) hasWatches(vacanciesOnly bool) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, w := range s.watches {
+		if w.vacanciesOnly == vacanciesOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluate alerts the watches matching the text of a chunk (or of its whole message) and its embedding.
+func (s *Service) evaluate(ctx context.Context, chunk *core.Record, text string, vector []float32, vacancies bool) {
+	s.mu.RLock()
+	watches, send := s.watches, s.send
+	s.mu.RUnlock()
+	if send == nil {
+		return
+	}
+
+	lower := strings.ToLower(text)
+	for _, w := range watches {
+		if w.vacanciesOnly != vacancies {
+			continue
+		}
+		if w.channelID != "" && w.channelID != chunk.GetString("channelId") {
+			continue
+		}
+
+		score := semanticRatio*embedding.Cosine(w.embedding, vector) + (1-semanticRatio)*keywordScore(w.terms, lower)
+		if score < w.threshold {
+			continue
+		}
+
+		if err := s.alert(ctx, send, w, chunk, score); err != nil {
+			s.logger.Error("Failed to alert watch",
+				zap.String("watch", w.id),
+				zap.String("chunk", chunk.Id),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// alert records and delivers a match, once per watch and message (whichever of its parts
+// matches first) and within the user's rate limit.
+func (s *Service) alert(ctx context.Context, send Sender, w watch, chunk *core.Record, score float64) error {
+	filter := "watch = {:watch} && chunk = {:chunk}"
+	if chunk.GetInt("msgId") > 0 {
+		filter = "watch = {:watch} && chunk.channelId = {:channelId} && chunk.msgId = {:msgId}"
+	}
+	previous, err := s.app.FindRecordsByFilter(AlertsCollectionName, filter, "", 1, 0, dbx.Params{
+		"watch":     w.id,
+		"chunk":     chunk.Id,
+		"channelId": chunk.GetString("channelId"),
+		"msgId":     chunk.GetInt("msgId"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to check previous alerts: %w", err)
+	}
+	if len(previous) > 0 {
+		return nil
+	}
+
+	collection, err := s.app.FindCollectionByNameOrId(AlertsCollectionName)
+	if err != nil {
+		return fmt.Errorf("watch_alerts collection not found: %w", err)
+	}
+	record := core.NewRecord(collection)
+	record.Set("watch", w.id)
+	record.Set("user", w.user)
+	record.Set("chunk", chunk.Id)
+	record.Set("score", score)
+
+	sent, err := s.app.CountRecords(AlertsCollectionName,
+		dbx.HashExp{"user": w.user, "status": StatusSent},
+		dbx.NewExp("created >= {:since}", dbx.Params{"since": types.NowDateTime().Add(-time.Hour).String()}),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to count recent alerts: %w", err)
+	}
+
+	if s.rateLimit > 0 && int(sent) >= s.rateLimit {
+		// Recorded but not delivered, so the match is not retried either
+		record.Set("status", StatusRateLimited)
+	} else if err := s.deliver(ctx, send, w, chunk, score); err != nil {
+		record.Set("status", StatusFailed)
+		record.Set("error", err.Error())
+	} else {
+		record.Set("status", StatusSent)
+	}
+
+	if err := s.app.Save(record); err != nil {
+		return fmt.Errorf("failed to save alert: %w", err)
+	}
+
+	s.logger.Info("Watch matched",
+		zap.String("watch", w.id),
+		zap.String("chunk", chunk.Id),
+		zap.Float64("score", score),
+		zap.String("status", record.GetString("status")),
+	)
+
+	return nil
+}
+
+// deliver sends an alert to the Telegram account linked by the owner of the watch.
+func (s *Service) deliver(ctx context.Context, send Sender, w watch, chunk *core.Record, score float64) error {
+	account, err := s.account(w.user)
+	if err != nil {
+		return err
+	}
+	return send(ctx, account, alertText(w, chunk, score))
+}
+
+func alertText(w watch, chunk *core.Record, score float64) string {
+	snippet := []rune(chunk.GetString("content"))
+	if len(snippet) > snippetLength {
+		snippet = append(snippet[:snippetLength], '…')
+	}
+	return fmt.Sprintf("🔔 New match for \"%s\" (score %.2f)\n\n%s\n\n%s", w.query, score, string(snippet), chunk.GetString("link"))
+}
+
+// terms splits a query into lowercased words for keyword matching.
+func terms(query string) []string {
+	var out []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) > 1 {
+			out = append(out, word)
+		}
+	}
+	return out
+}
+
+// keywordScore is the fraction of query terms found in the (lowercased) content.
+func keywordScore(terms []string, content string) float64 {
+	if len(terms) == 0 {
+		return 0
+	}
+	found := 0
+	for _, term := range terms {
+		if strings.Contains(content, term) {
+			found++
+		}
+	}
+	return float64(found) / float64(len(terms))
+}